@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/stream"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errStop stops reading the segments once the end of the range is passed
+var errStop = errors.New("stop")
+
+// Archive reads the archive of a topic
+type Archive struct {
+	rsq.ILogger
+
+	topic string
+	dir   string
+}
+
+// Open opens the archive of topic in dir
+func Open(dir, topic string, l rsq.ILogger) (*Archive, error) {
+	a := &Archive{ILogger: l, topic: topic, dir: topicDir(dir, topic)}
+
+	if _, err := os.Stat(a.dir); err != nil {
+		return nil, fmt.Errorf("no archive of %s in %s: %w", topic, dir, err)
+	}
+
+	return a, nil
+}
+
+func (a *Archive) Topic() string {
+	return a.topic
+}
+
+// Segments lists the segments of the archive from the oldest one
+func (a *Archive) Segments() ([]*Segment, error) {
+	x, err := readIndex(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return x.Segments, nil
+}
+
+// Replay calls fn with the records added in [from, to) in their order, a zero time is no bound
+func (a *Archive) Replay(ctx context.Context, from, to time.Time, fn func(r *Record) error) error {
+	segments, err := a.Segments()
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if !seg.overlaps(from, to) {
+			continue
+		}
+
+		err = readSegment(filepath.Join(a.dir, seg.File), seg.Open, func(r *Record) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			t := r.Time()
+			if !from.IsZero() && t.Before(from) {
+				return nil
+			}
+
+			if !to.IsZero() && !t.Before(to) {
+				return errStop
+			}
+
+			return fn(r)
+		})
+
+		if err == errStop {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplayEntries calls fn with the decoded entries added in [from, to)
+func (a *Archive) ReplayEntries(ctx context.Context, from, to time.Time, fn func(e *stream.Entry) error) error {
+	return a.Replay(ctx, from, to, func(r *Record) error {
+		return fn(stream.DecodeEntry(a.topic, r.message(), a.ILogger))
+	})
+}
+
+// Republish adds the entries added in [from, to) to topic again, they get new ids.
+// It returns the number of published entries.
+func (a *Archive) Republish(ctx context.Context, cl redis.UniversalClient, topic string, from, to time.Time, maxLen int64) (int64, error) {
+	n := int64(0)
+
+	err := a.Replay(ctx, from, to, func(r *Record) error {
+		err := cl.XAdd(ctx, &redis.XAddArgs{
+			Stream: topic,
+			MaxLen: maxLen,
+			Approx: true,
+			Values: r.message().Values,
+		}).Err()
+		if err != nil {
+			return err
+		}
+
+		n++
+		return nil
+	})
+
+	return n, err
+}
+
+func (r *Record) message() redis.XMessage {
+	values := make(map[string]interface{}, len(r.Values))
+	for k, v := range r.Values {
+		values[k] = v
+	}
+
+	return redis.XMessage{ID: r.Id, Values: values}
+}
@@ -0,0 +1,316 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/stream"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GuardGroup is the group kept at the last archived entry, so that a safe retention doesn't trim the entries not archived yet
+const GuardGroup = "_archive"
+
+type Option func(a *Archiver)
+
+// WithSegmentSize sets the bytes of records, before compression, after which a segment is sealed
+func WithSegmentSize(n int64) Option {
+	return func(a *Archiver) {
+		if n > 0 {
+			a.segmentSize = n
+		}
+	}
+}
+
+// WithSegmentAge sets how long a segment is written before it is sealed
+func WithSegmentAge(d time.Duration) Option {
+	return func(a *Archiver) {
+		if d > 0 {
+			a.segmentAge = d
+		}
+	}
+}
+
+// WithReadCount sets the max entries read at once
+func WithReadCount(n int64) Option {
+	return func(a *Archiver) {
+		if n > 0 {
+			a.readCount = n
+		}
+	}
+}
+
+// WithBlock sets how long a read waits for new entries
+func WithBlock(d time.Duration) Option {
+	return func(a *Archiver) {
+		if d > 0 {
+			a.block = d
+		}
+	}
+}
+
+// WithGuard sets whether the archiver keeps the group GuardGroup at the last archived entry, true by default
+func WithGuard(guard bool) Option {
+	return func(a *Archiver) {
+		a.guard = guard
+	}
+}
+
+// Archiver copies the entries of a topic to the segments of its archive in dir.
+// It resumes after the last archived entry, a single archiver must write an archive.
+type Archiver struct {
+	rsq.ILogger
+
+	topic       string
+	dir         string
+	client      redis.UniversalClient
+	segmentSize int64
+	segmentAge  time.Duration
+	readCount   int64
+	block       time.Duration
+	guard       bool
+
+	index  *index
+	w      *segmentWriter
+	lastId string
+
+	quit chan bool
+	done chan bool
+	once sync.Once
+}
+
+func NewArchiver(topic, dir string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Archiver {
+	a := &Archiver{
+		ILogger: l,
+
+		topic:       topic,
+		dir:         topicDir(dir, topic),
+		client:      cl,
+		segmentSize: 64 << 20,
+		segmentAge:  time.Hour,
+		readCount:   1000,
+		block:       time.Second,
+		guard:       true,
+		lastId:      "0-0",
+		quit:        make(chan bool),
+		done:        make(chan bool),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Start recovers the archive and starts copying the entries
+func (a *Archiver) Start() error {
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if info, err := stream.NewAdmin(a.client, a.ILogger).StreamInfo(ctx, a.topic); err == nil {
+		if a.lastId != "0-0" && stream.CompareEntryId(info.MaxDeletedId, a.lastId) > 0 {
+			a.Warnf("archive of %s may miss entries deleted after %s", a.topic, a.lastId)
+		}
+	}
+
+	if a.guard {
+		err := a.client.XGroupCreate(ctx, a.topic, GuardGroup, a.lastId).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			a.Warnf("create archive guard of %s failed: %s", a.topic, err)
+		}
+	}
+
+	go a.run()
+
+	return nil
+}
+
+// Stop waits for the archiver to write its last read entries and seals the open segment
+func (a *Archiver) Stop() {
+	a.once.Do(func() {
+		close(a.quit)
+		<-a.done
+	})
+}
+
+// open reads the index and seals the segment left open by a previous archiver
+func (a *Archiver) open() error {
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+
+	x, err := readIndex(a.dir)
+	if err != nil {
+		return err
+	}
+	a.index = x
+
+	if n := len(x.Segments); n > 0 {
+		last := x.Segments[n-1]
+		if last.Open {
+			if err = a.recover(last); err != nil {
+				return fmt.Errorf("recover segment %s: %w", last.File, err)
+			}
+
+			if err = x.write(a.dir); err != nil {
+				return err
+			}
+		}
+
+		if last.LastId != "" {
+			a.lastId = last.LastId
+		}
+	}
+
+	return nil
+}
+
+// recover rewrites the records of an open segment which may be cut, and seals it
+func (a *Archiver) recover(seg *Segment) error {
+	path := filepath.Join(a.dir, seg.File)
+	tmp := path + ".tmp"
+
+	*seg = Segment{File: seg.File, Open: true}
+
+	w, err := createSegment(tmp, seg)
+	if err != nil {
+		return err
+	}
+
+	if err = readSegment(path, true, w.write); err != nil {
+		_ = w.close()
+		return err
+	}
+
+	if err = w.close(); err != nil {
+		return err
+	}
+
+	a.Infof("archive of %s recovered %d entries of segment %s", a.topic, seg.Count, seg.File)
+
+	return os.Rename(tmp, path)
+}
+
+func (a *Archiver) run() {
+	defer close(a.done)
+
+	ctx := context.Background()
+
+	for {
+		select {
+		case <-a.quit:
+			if err := a.seal(); err != nil {
+				a.Errorf("archive of %s seal failed: %s", a.topic, err)
+			}
+			return
+		default:
+		}
+
+		data, err := a.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{a.topic, a.lastId},
+			Count:   a.readCount,
+			Block:   a.block,
+		}).Result()
+
+		if err != nil && err != redis.Nil {
+			a.Errorf("archive of %s read failed: %s", a.topic, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err = a.archive(data); err != nil {
+			a.Errorf("archive of %s write failed: %s", a.topic, err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if a.w != nil && (a.w.seg.Size >= a.segmentSize || time.Since(a.w.opened) >= a.segmentAge) {
+			if err = a.seal(); err != nil {
+				a.Errorf("archive of %s seal failed: %s", a.topic, err)
+			}
+		}
+	}
+}
+
+// archive writes the read entries and moves the guard after them
+func (a *Archiver) archive(data []redis.XStream) error {
+	lastId := a.lastId
+
+	for _, s := range data {
+		for _, m := range s.Messages {
+			r := &Record{Id: m.ID, Values: make(map[string]string, len(m.Values))}
+			for k, v := range m.Values {
+				r.Values[k] = fmt.Sprint(v)
+			}
+
+			if err := a.write(r); err != nil {
+				return err
+			}
+			lastId = m.ID
+		}
+	}
+
+	if lastId == a.lastId {
+		return nil
+	}
+
+	if err := a.w.flush(); err != nil {
+		return err
+	}
+
+	if err := a.index.write(a.dir); err != nil {
+		return err
+	}
+
+	a.lastId = lastId
+
+	if a.guard {
+		if err := a.client.XGroupSetID(context.Background(), a.topic, GuardGroup, lastId).Err(); err != nil {
+			a.Warnf("move archive guard of %s failed: %s", a.topic, err)
+		}
+	}
+
+	return nil
+}
+
+// write appends r to the open segment, a segment is created for the first record
+func (a *Archiver) write(r *Record) error {
+	if a.w == nil {
+		seg := &Segment{File: segmentName(r.Id), Open: true}
+
+		w, err := createSegment(filepath.Join(a.dir, seg.File), seg)
+		if err != nil {
+			return err
+		}
+
+		a.w = w
+		a.index.Segments = append(a.index.Segments, seg)
+	}
+
+	return a.w.write(r)
+}
+
+// seal closes the open segment
+func (a *Archiver) seal() error {
+	if a.w == nil {
+		return nil
+	}
+
+	w := a.w
+	a.w = nil
+
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	return a.index.write(a.dir)
+}
@@ -0,0 +1,222 @@
+// Package archive copies the entries of topics to compressed segment files before they are trimmed,
+// and replays them for a time range.
+//
+// The archive of a topic is a directory holding its segments, gzip compressed json lines of the raw entries,
+// and index.json listing them by entry id and time.
+package archive
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const indexFile = "index.json"
+
+// Record is an archived stream entry with its raw fields
+type Record struct {
+	Id     string            `json:"id"`
+	Values map[string]string `json:"values"`
+}
+
+// Time returns the time the entry was added to the stream
+func (r *Record) Time() time.Time {
+	return idTime(r.Id)
+}
+
+// Segment describes a segment file of the archive
+type Segment struct {
+	File    string    `json:"file"`
+	FirstId string    `json:"firstId"`
+	LastId  string    `json:"lastId"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	Count   int64     `json:"count"`
+	Size    int64     `json:"size"`           //bytes before compression
+	Open    bool      `json:"open,omitempty"` //still written by the archiver
+}
+
+// overlaps tells whether the segment may hold entries in [from, to), a zero time is no bound
+func (s *Segment) overlaps(from, to time.Time) bool {
+	if !from.IsZero() && s.Last.Before(from) && !s.Open {
+		return false
+	}
+
+	return to.IsZero() || s.First.Before(to)
+}
+
+type index struct {
+	Segments []*Segment `json:"segments"`
+}
+
+func topicDir(dir, topic string) string {
+	return filepath.Join(dir, url.PathEscape(topic))
+}
+
+// readIndex reads the index of the archive in dir, it is empty when there is none
+func readIndex(dir string) (*index, error) {
+	b, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &index{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	x := &index{}
+	if err = json.Unmarshal(b, x); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %w", dir, err)
+	}
+
+	return x, nil
+}
+
+// write replaces the index atomically
+func (x *index) write(dir string) error {
+	b, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, indexFile+".tmp")
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, indexFile))
+}
+
+// segmentWriter appends records to a segment file
+type segmentWriter struct {
+	seg    *Segment
+	f      *os.File
+	zw     *gzip.Writer
+	opened time.Time
+}
+
+func createSegment(path string, seg *Segment) (*segmentWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segmentWriter{seg: seg, f: f, zw: gzip.NewWriter(f), opened: time.Now()}, nil
+}
+
+func (w *segmentWriter) write(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	b = append(b, '\n')
+	if _, err = w.zw.Write(b); err != nil {
+		return err
+	}
+
+	s := w.seg
+	if s.Count == 0 {
+		s.FirstId, s.First = r.Id, r.Time()
+	}
+	s.LastId, s.Last = r.Id, r.Time()
+	s.Count++
+	s.Size += int64(len(b))
+
+	return nil
+}
+
+// flush makes the written records durable and readable
+func (w *segmentWriter) flush() error {
+	if err := w.zw.Flush(); err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+// close seals the segment
+func (w *segmentWriter) close() error {
+	if err := w.zw.Close(); err != nil {
+		_ = w.f.Close()
+		return err
+	}
+
+	if err := w.f.Sync(); err != nil {
+		_ = w.f.Close()
+		return err
+	}
+
+	w.seg.Open = false
+
+	return w.f.Close()
+}
+
+// readSegment reads the records of a segment file, the tail of an open segment cut by a crash is ignored
+func readSegment(path string, open bool, fn func(r *Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	zr, err := gzip.NewReader(f)
+	if err == io.EOF {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(zr)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+
+		var corrupt flate.CorruptInputError
+		if open && (errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		r := &Record{}
+		if err = json.Unmarshal(line, r); err != nil {
+			return fmt.Errorf("invalid record in %s: %w", path, err)
+		}
+
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// idTime returns the time of a stream entry id
+func idTime(id string) time.Time {
+	s, _, _ := strings.Cut(id, "-")
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
+
+func segmentName(firstId string) string {
+	return firstId + ".seg.gz"
+}
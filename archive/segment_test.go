@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"github.com/wsk15046/rsq/klog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoverAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	l := klog.NewKLog(&klog.LogOpt{LogLevel: "error"})
+
+	a := NewArchiver("rsq:archive_test", dir, nil, l)
+	if err := a.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	//10 entries 1s apart, the last segment is left open as by a crash
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 10; i++ {
+		r := &Record{Id: fmt.Sprintf("%d-0", base.Add(time.Duration(i)*time.Second).UnixMilli()), Values: map[string]string{"k": "v"}}
+		if err := a.write(r); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 4 {
+			if err := a.seal(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := a.w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.index.write(a.dir); err != nil {
+		t.Fatal(err)
+	}
+
+	//the tail of the open segment is cut
+	f, err := os.OpenFile(filepath.Join(a.dir, a.w.seg.File), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0x1f, 0x8b})
+	_ = f.Close()
+
+	b := NewArchiver("rsq:archive_test", dir, nil, l)
+	if err = b.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.lastId != fmt.Sprintf("%d-0", base.Add(9*time.Second).UnixMilli()) {
+		t.Fatalf("unexpected last id %s", b.lastId)
+	}
+
+	ar, err := Open(dir, "rsq:archive_test", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := ar.Segments()
+	if len(segments) != 2 || segments[1].Open || segments[1].Count != 5 {
+		t.Fatalf("unexpected segments %+v", segments)
+	}
+
+	var ids []string
+	err = ar.Replay(context.Background(), base.Add(3*time.Second), base.Add(7*time.Second), func(r *Record) error {
+		ids = append(ids, r.Id)
+		return nil
+	})
+
+	if err != nil || len(ids) != 4 || ids[0] != fmt.Sprintf("%d-0", base.Add(3*time.Second).UnixMilli()) {
+		t.Fatalf("unexpected replay %v %v", ids, err)
+	}
+}
@@ -0,0 +1,77 @@
+// rsq-gateway serves rsq topics over http, see package gateway for the endpoints.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq/gateway"
+	"github.com/wsk15046/rsq/klog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "comma separated redis addresses, several ones for a cluster")
+	password := flag.String("password", "", "redis password")
+	db := flag.Int("db", 0, "redis db, ignored by a cluster")
+	listen := flag.String("listen", ":8080", "http listen address")
+	maxLen := flag.Int64("maxlen", 10000, "approximate max length of the topics")
+	ackTimeout := flag.Duration("ack-timeout", time.Minute, "delay before an unacked entry is delivered again")
+	maxInflight := flag.Int64("max-inflight", 1000, "max unacked entries of an event stream")
+	topics := flag.String("topics", "", "comma separated patterns of the topics served, required")
+	maxGroups := flag.Int("max-groups", 1000, "max group consumers kept by the gateway")
+	groupIdle := flag.Duration("group-idle", 10*time.Minute, "how long an unused group consumer is kept")
+	logLevel := flag.String("log-level", "info", "log level")
+	logPath := flag.String("log-path", "", "log file, stdout only when empty")
+	flag.Parse()
+
+	l := klog.NewKLog(&klog.LogOpt{
+		LogLevel:    *logLevel,
+		InfoLogPath: *logPath,
+		MaxAgeDay:   7,
+		RotateDay:   1,
+		RotateSizeM: 1000,
+	})
+
+	if *topics == "" {
+		l.Fatalf("rsq-gateway: -topics is required")
+	}
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    strings.Split(*addr, ","),
+		Password: *password,
+		DB:       *db,
+	})
+
+	gw := gateway.New(client, l,
+		gateway.WithMaxLen(*maxLen),
+		gateway.WithAckTimeout(*ackTimeout),
+		gateway.WithMaxInflight(*maxInflight),
+		gateway.WithMaxGroups(*maxGroups, *groupIdle),
+		gateway.WithAuth(gateway.AllowTopics(strings.Split(*topics, ",")...)))
+
+	srv := &http.Server{Addr: *listen, Handler: gw}
+
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		<-ch
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	l.Infof("rsq-gateway listening on %s", *listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		l.Fatalf("rsq-gateway failed: %s", err)
+	}
+
+	gw.Close()
+	_ = client.Close()
+}
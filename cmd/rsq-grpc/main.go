@@ -0,0 +1,85 @@
+// rsq-grpc serves rsq topics over grpc, see rpc/rsqpb/rsq.proto for the service.
+package main
+
+import (
+	"flag"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq/klog"
+	"github.com/wsk15046/rsq/rpc"
+	"github.com/wsk15046/rsq/rpc/rsqpb"
+	"google.golang.org/grpc"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "comma separated redis addresses, several ones for a cluster")
+	password := flag.String("password", "", "redis password")
+	db := flag.Int("db", 0, "redis db, ignored by a cluster")
+	listen := flag.String("listen", ":9090", "grpc listen address")
+	maxLen := flag.Int64("maxlen", 10000, "approximate max length of the topics")
+	ackTimeout := flag.Duration("ack-timeout", time.Minute, "default delay before an unacked entry is delivered again")
+	maxInflight := flag.Int64("max-inflight", 1000, "default max unacked entries of a subscriber")
+	topics := flag.String("topics", "", "comma separated patterns of the topics served, required")
+	maxGroups := flag.Int("max-groups", 1000, "max group consumers kept by the server")
+	groupIdle := flag.Duration("group-idle", 10*time.Minute, "how long an unused group consumer is kept")
+	logLevel := flag.String("log-level", "info", "log level")
+	logPath := flag.String("log-path", "", "log file, stdout only when empty")
+	flag.Parse()
+
+	l := klog.NewKLog(&klog.LogOpt{
+		LogLevel:    *logLevel,
+		InfoLogPath: *logPath,
+		MaxAgeDay:   7,
+		RotateDay:   1,
+		RotateSizeM: 1000,
+	})
+
+	if *topics == "" {
+		l.Fatalf("rsq-grpc: -topics is required")
+	}
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    strings.Split(*addr, ","),
+		Password: *password,
+		DB:       *db,
+	})
+
+	s := rpc.NewServer(client, l,
+		rpc.WithMaxLen(*maxLen),
+		rpc.WithAckTimeout(*ackTimeout),
+		rpc.WithMaxInflight(*maxInflight),
+		rpc.WithMaxGroups(*maxGroups, *groupIdle),
+		rpc.WithAuth(rpc.AllowTopics(strings.Split(*topics, ",")...)))
+
+	srv := grpc.NewServer()
+	rsqpb.RegisterQueueServer(srv, s)
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		l.Fatalf("rsq-grpc listen failed: %s", err)
+	}
+
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		<-ch
+
+		// subscribe streams only end with their clients, they are cut after a delay
+		t := time.AfterFunc(10*time.Second, srv.Stop)
+		srv.GracefulStop()
+		t.Stop()
+	}()
+
+	l.Infof("rsq-grpc listening on %s", *listen)
+	if err = srv.Serve(lis); err != nil {
+		l.Fatalf("rsq-grpc failed: %s", err)
+	}
+
+	s.Close()
+	_ = client.Close()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/wsk15046/rsq/archive"
+	"github.com/wsk15046/rsq/stream"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFlag is a time given as RFC3339, or as a duration before now
+type timeFlag struct {
+	t time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(s string) error {
+	if d, err := time.ParseDuration(s); err == nil {
+		f.t = time.Now().Add(-d)
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("invalid time %s, expect RFC3339 or a duration before now", s)
+	}
+
+	f.t = t
+	return nil
+}
+
+func archiveRun(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("archive run", flag.ContinueOnError)
+	dir := fs.String("dir", "archive", "directory of the archives")
+	size := fs.Int64("segment-size", 64<<20, "bytes of a segment before compression")
+	age := fs.Duration("segment-age", time.Hour, "max time a segment is written")
+	guard := fs.Bool("guard", true, "keep the group "+archive.GuardGroup+" at the last archived entry")
+
+	args, err := parseFlags(fs, args, 1, "archive run [-dir d] [-segment-size n] [-segment-age d] [-guard=false] <topic>...")
+	if err != nil {
+		return err
+	}
+
+	var archivers []*archive.Archiver
+	defer func() {
+		for _, ar := range archivers {
+			ar.Stop()
+		}
+	}()
+
+	for _, topic := range args {
+		ar := archive.NewArchiver(topic, *dir, a.client, a.log,
+			archive.WithSegmentSize(*size), archive.WithSegmentAge(*age), archive.WithGuard(*guard))
+		if err = ar.Start(); err != nil {
+			return err
+		}
+		archivers = append(archivers, ar)
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+func archiveList(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("archive list", flag.ContinueOnError)
+	dir := fs.String("dir", "archive", "directory of the archives")
+
+	args, err := parseFlags(fs, args, 1, "archive list [-dir d] <topic>")
+	if err != nil {
+		return err
+	}
+
+	ar, err := archive.Open(*dir, args[0], a.log)
+	if err != nil {
+		return err
+	}
+
+	segments, err := ar.Segments()
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(segments))
+	for _, s := range segments {
+		rows = append(rows, []string{
+			s.File,
+			s.FirstId,
+			s.LastId,
+			s.First.Format(time.RFC3339),
+			s.Last.Format(time.RFC3339),
+			strconv.FormatInt(s.Count, 10),
+			strconv.FormatInt(s.Size, 10),
+			strconv.FormatBool(s.Open),
+		})
+	}
+
+	return a.out.print(segments, []string{"FILE", "FIRST-ID", "LAST-ID", "FIRST", "LAST", "COUNT", "SIZE", "OPEN"}, rows)
+}
+
+func archiveReplay(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("archive replay", flag.ContinueOnError)
+	dir := fs.String("dir", "archive", "directory of the archives")
+	from, to := &timeFlag{}, &timeFlag{}
+	fs.Var(from, "from", "replay the entries added from this time")
+	fs.Var(to, "to", "replay the entries added before this time")
+	target := fs.String("publish", "", "topic the entries are published to, they are printed by default")
+	maxLen := fs.Int64("maxlen", 10000, "approximate max length of the published topic")
+
+	args, err := parseFlags(fs, args, 1, "archive replay [-dir d] [-from t] [-to t] [-publish topic] <topic>")
+	if err != nil {
+		return err
+	}
+
+	ar, err := archive.Open(*dir, args[0], a.log)
+	if err != nil {
+		return err
+	}
+
+	if *target != "" {
+		n, err := ar.Republish(ctx, a.client, *target, from.t, to.t, *maxLen)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(a.out.w, "%d entries published to %s\n", n, *target)
+		return err
+	}
+
+	enc := json.NewEncoder(a.out.w)
+
+	return ar.ReplayEntries(ctx, from.t, to.t, func(e *stream.Entry) error {
+		for _, m := range e.Messages {
+			if a.out.json {
+				if err := enc.Encode(newJsonMessage(m)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := fmt.Fprintln(a.out.w, strings.Join(messageRow(m), "\t")); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/wsk15046/rsq/stream"
+)
+
+func consumerPause(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("consumer pause", flag.ContinueOnError), args, 2, "consumer pause <topic> <consumer>")
+	if err != nil {
+		return err
+	}
+
+	if err = a.admin.PauseConsumer(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "consumer %s of %s paused\n", args[1], args[0])
+	return err
+}
+
+func consumerResume(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("consumer resume", flag.ContinueOnError), args, 2, "consumer resume <topic> <consumer>")
+	if err != nil {
+		return err
+	}
+
+	if err = a.admin.ResumeConsumer(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "consumer %s of %s resumed\n", args[1], args[0])
+	return err
+}
+
+func consumerSeek(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("consumer seek", flag.ContinueOnError)
+	at := &timeFlag{}
+	fs.Var(at, "time", "read the entries added from this time instead of an id")
+
+	args, err := parseFlags(fs, args, 2, "consumer seek [-time t] <topic> <consumer> [id]")
+	if err != nil {
+		return err
+	}
+
+	var id string
+	switch {
+	case !at.t.IsZero():
+		id = stream.SeekId(at.t)
+	case len(args) > 2:
+		id = args[2]
+	default:
+		fs.Usage()
+		return fmt.Errorf("expect an id or -time")
+	}
+
+	if err = a.admin.SeekConsumer(ctx, args[0], args[1], id); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "consumer %s of %s seeks to %s at its next report\n", args[1], args[0], id)
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+func dlqList(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("dlq list", flag.ContinueOnError)
+	from := fs.String("from", "-", "entry id to start from")
+	count := fs.Int64("n", 100, "max entries")
+
+	args, err := parseFlags(fs, args, 1, "dlq list [-from id] [-n count] <topic>")
+	if err != nil {
+		return err
+	}
+
+	letters, err := a.admin.DeadLetters(ctx, args[0], *from, *count)
+	if err != nil {
+		return err
+	}
+
+	type jsonLetter struct {
+		EntryId  string         `json:"entryId"`
+		Origin   string         `json:"origin"`
+		Group    string         `json:"group"`
+		Reason   string         `json:"reason"`
+		Messages []*jsonMessage `json:"messages"`
+	}
+
+	var rows [][]string
+	jls := make([]*jsonLetter, 0, len(letters))
+
+	for _, l := range letters {
+		jl := &jsonLetter{EntryId: l.Id, Origin: l.Origin, Group: l.Group, Reason: l.Reason}
+		for _, m := range l.Messages {
+			jl.Messages = append(jl.Messages, newJsonMessage(m))
+			rows = append(rows, append([]string{l.Origin, l.Group, l.Reason}, messageRow(m)...))
+		}
+		jls = append(jls, jl)
+	}
+
+	header := append([]string{"ORIGIN", "GROUP", "REASON"}, messageHeader...)
+
+	return a.out.print(jls, header, rows)
+}
+
+func dlqRedrive(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("dlq redrive", flag.ContinueOnError)
+	maxLen := fs.Int64("maxlen", -1, "approximate max length the topic is trimmed to, the registered one by default, 0 for no trim")
+	group := fs.Bool("group", false, "hand the entries to the group which dead lettered them instead of every group of the topic")
+
+	args, err := parseFlags(fs, args, 1, "dlq redrive [-maxlen n] [-group] <topic> [id]...")
+	if err != nil {
+		return err
+	}
+
+	if *group {
+		n, err := a.admin.RedriveGroup(ctx, args[0], args[1:]...)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(a.out.w, "%d entries redriven to their group\n", n)
+		return err
+	}
+
+	if *maxLen < 0 {
+		*maxLen = 0
+		if cfg, err := a.reg.Topic(ctx, args[0]); err == nil {
+			*maxLen = cfg.MaxLen
+		}
+	}
+
+	n, err := a.admin.Redrive(ctx, args[0], *maxLen, args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "%d entries redriven to %s\n", n, args[0])
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+func groupList(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("group list", flag.ContinueOnError), args, 1, "group list <topic>")
+	if err != nil {
+		return err
+	}
+
+	groups, err := a.admin.Groups(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, []string{
+			g.Name,
+			strconv.FormatInt(g.Consumers, 10),
+			strconv.FormatInt(g.Pending, 10),
+			strconv.FormatInt(g.Lag, 10),
+			g.LastDeliveredId,
+		})
+	}
+
+	return a.out.print(groups, []string{"GROUP", "CONSUMERS", "PENDING", "LAG", "LAST-DELIVERED"}, rows)
+}
+
+func groupConsumers(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("group consumers", flag.ContinueOnError), args, 2, "group consumers <topic> <group>")
+	if err != nil {
+		return err
+	}
+
+	consumers, err := a.admin.Consumers(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(consumers))
+	for _, c := range consumers {
+		rows = append(rows, []string{c.Name, strconv.FormatInt(c.Pending, 10), c.Idle.String()})
+	}
+
+	return a.out.print(consumers, []string{"CONSUMER", "PENDING", "IDLE"}, rows)
+}
+
+func groupReset(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("group reset", flag.ContinueOnError), args, 3, "group reset <topic> <group> <id>")
+	if err != nil {
+		return err
+	}
+
+	if err = a.admin.ResetGroup(ctx, args[0], args[1], args[2]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "group %s of %s reset to %s\n", args[1], args[0], args[2])
+	return err
+}
+
+func groupDelete(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("group delete", flag.ContinueOnError), args, 2, "group delete <topic> <group>")
+	if err != nil {
+		return err
+	}
+
+	if err = a.admin.DeleteGroup(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "group %s of %s deleted\n", args[1], args[0])
+	return err
+}
+
+func pendingList(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("pending list", flag.ContinueOnError)
+	consumer := fs.String("consumer", "", "only the entries of this consumer")
+	count := fs.Int64("n", 100, "max entries")
+
+	args, err := parseFlags(fs, args, 2, "pending list [-consumer name] [-n count] <topic> <group>")
+	if err != nil {
+		return err
+	}
+
+	entries, err := a.admin.Pending(ctx, args[0], args[1], *consumer, *count)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		rows = append(rows, []string{e.Id, e.Consumer, e.Idle.String(), strconv.FormatInt(e.DeliveryCount, 10)})
+	}
+
+	return a.out.print(entries, []string{"ENTRY", "CONSUMER", "IDLE", "DELIVERIES"}, rows)
+}
+
+func pendingClaim(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("pending claim", flag.ContinueOnError)
+	minIdle := fs.Duration("min-idle", time.Minute, "only claim entries idle longer than this")
+
+	args, err := parseFlags(fs, args, 4, "pending claim [-min-idle d] <topic> <group> <consumer> <id>...")
+	if err != nil {
+		return err
+	}
+
+	ids, err := a.admin.Claim(ctx, args[0], args[1], args[2], *minIdle, args[3:]...)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, []string{id})
+	}
+
+	return a.out.print(ids, []string{"CLAIMED"}, rows)
+}
@@ -0,0 +1,160 @@
+// rsqctl inspects and operates rsq topics.
+//
+//	rsqctl [-addr host:port,...] [-password pwd] [-db n] [-o table|json] <command> [args]
+//
+// commands:
+//
+//	topic list [pattern]
+//	topic info <topic>
+//	topic trim [-max-age d] [-maxlen n] [-max-memory bytes] [-safe] [-exact] <topic>
+//	topic create [-update] [-maxlen n] [-max-age d] [-routing tag|broadcast] [-single-entry] [-retry-delayed n] ... <topic>
+//	topic describe <topic>
+//	topic delete <topic>
+//	tail [-from id] <topic>
+//	publish [-id id] [-tag t1,t2] [-H k=v] [-check=false] <topic>  one message per line of stdin
+//	group list <topic>
+//	group consumers <topic> <group>
+//	group reset <topic> <group> <id>
+//	group delete <topic> <group>
+//	pending list [-consumer name] [-n count] <topic> <group>
+//	pending claim [-min-idle d] <topic> <group> <consumer> <id>...
+//	dlq list [-from id] [-n count] <topic>
+//	dlq redrive <topic> [id]...
+//	consumer pause <topic> <consumer>
+//	consumer resume <topic> <consumer>
+//	consumer seek [-time t] <topic> <consumer> [id]
+//	stats <topic>
+//	archive run [-dir d] [-segment-size n] [-segment-age d] [-guard=false] <topic>...
+//	archive list [-dir d] <topic>
+//	archive replay [-dir d] [-from t] [-to t] [-publish topic] <topic>
+//	namespace list
+//	namespace topics <namespace>
+//	namespace quota [-max-topics n] [-maxlen n] [-rate r] <namespace>
+//	namespace purge <namespace>
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/wsk15046/rsq/stream"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+type command func(ctx context.Context, app *app, args []string) error
+
+var commands = map[string]map[string]command{
+	"topic":     {"list": topicList, "info": topicInfo, "trim": topicTrim, "create": topicCreate, "describe": topicDescribe, "delete": topicDelete},
+	"tail":      {"": tail},
+	"publish":   {"": publish},
+	"group":     {"list": groupList, "consumers": groupConsumers, "reset": groupReset, "delete": groupDelete},
+	"pending":   {"list": pendingList, "claim": pendingClaim},
+	"dlq":       {"list": dlqList, "redrive": dlqRedrive},
+	"consumer":  {"pause": consumerPause, "resume": consumerResume, "seek": consumerSeek},
+	"stats":     {"": stats},
+	"archive":   {"run": archiveRun, "list": archiveList, "replay": archiveReplay},
+	"namespace": {"list": namespaceList, "topics": namespaceTopics, "quota": namespaceQuota, "purge": namespacePurge},
+}
+
+type app struct {
+	client redis.UniversalClient
+	admin  *stream.Admin
+	reg    *stream.Registry
+	log    *logrus.Logger
+	out    *output
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "comma separated redis addresses, several ones for a cluster")
+	password := flag.String("password", "", "redis password")
+	db := flag.Int("db", 0, "redis db, ignored by a cluster")
+	format := flag.String("o", "table", "output format, table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, args, ok := lookup(args)
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	l := logrus.New()
+	l.SetOutput(os.Stderr)
+	l.SetLevel(logrus.WarnLevel)
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:    strings.Split(*addr, ","),
+		Password: *password,
+		DB:       *db,
+	})
+	defer func() { _ = client.Close() }()
+
+	a := &app{
+		client: client,
+		admin:  stream.NewAdmin(client, l),
+		reg:    stream.NewRegistry(client, l),
+		log:    l,
+		out:    &output{w: os.Stdout, json: *format == "json"},
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := cmd(ctx, a, args); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "rsqctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func lookup(args []string) (command, []string, bool) {
+	subs, ok := commands[args[0]]
+	if !ok {
+		return nil, nil, false
+	}
+
+	if cmd, ok := subs[""]; ok {
+		return cmd, args[1:], true
+	}
+
+	if len(args) < 2 {
+		return nil, nil, false
+	}
+
+	cmd, ok := subs[args[1]]
+	return cmd, args[2:], ok
+}
+
+func usage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: rsqctl [flags] <command> [args]")
+	_, _ = fmt.Fprintln(os.Stderr, "commands: topic list|info|trim|create|describe|delete, tail, publish, group list|consumers|reset|delete, pending list|claim, dlq list|redrive, consumer pause|resume|seek, stats, archive run|list|replay, namespace list|topics|quota|purge")
+	flag.PrintDefaults()
+}
+
+// parseFlags parses the flags of a command and checks it has at least n arguments
+func parseFlags(fs *flag.FlagSet, args []string, n int, usage string) ([]string, error) {
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: rsqctl %s\n", usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() < n {
+		fs.Usage()
+		return nil, fmt.Errorf("expect %d arguments, got %d", n, fs.NArg())
+	}
+
+	return fs.Args(), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/wsk15046/rsq/stream"
+	"strconv"
+)
+
+func namespaceList(ctx context.Context, a *app, args []string) error {
+	if _, err := parseFlags(flag.NewFlagSet("namespace list", flag.ContinueOnError), args, 0, "namespace list"); err != nil {
+		return err
+	}
+
+	names, err := a.admin.Namespaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, []string{name})
+	}
+
+	return a.out.print(names, []string{"NAMESPACE"}, rows)
+}
+
+func namespaceTopics(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("namespace topics", flag.ContinueOnError), args, 1, "namespace topics <namespace>")
+	if err != nil {
+		return err
+	}
+
+	ns, err := stream.NewNamespace(args[0], a.client, a.log)
+	if err != nil {
+		return err
+	}
+
+	topics, err := ns.Topics(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(topics))
+	for _, topic := range topics {
+		rows = append(rows, []string{topic, ns.Key(topic)})
+	}
+
+	return a.out.print(topics, []string{"TOPIC", "KEY"}, rows)
+}
+
+func namespaceQuota(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("namespace quota", flag.ContinueOnError)
+	maxTopics := fs.Int("max-topics", -1, "max topics of the namespace, 0 for no limit")
+	maxLen := fs.Int64("maxlen", -1, "max length kept by the producers of each topic, 0 for no limit")
+	rate := fs.Float64("rate", -1, "messages per second published to the namespace, 0 for no limit")
+
+	args, err := parseFlags(fs, args, 1, "namespace quota [-max-topics n] [-maxlen n] [-rate r] <namespace>")
+	if err != nil {
+		return err
+	}
+
+	ns, err := stream.NewNamespace(args[0], a.client, a.log)
+	if err != nil {
+		return err
+	}
+
+	q, err := ns.Quota(ctx)
+	if err != nil {
+		return err
+	}
+
+	//the quota is only shown without flags
+	set := false
+	if *maxTopics >= 0 {
+		q.MaxTopics, set = *maxTopics, true
+	}
+	if *maxLen >= 0 {
+		q.MaxLen, set = *maxLen, true
+	}
+	if *rate >= 0 {
+		q.PublishRate, set = *rate, true
+	}
+
+	if set {
+		if err = ns.SetQuota(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	return a.out.print(q, []string{"FIELD", "VALUE"}, [][]string{
+		{"max-topics", strconv.Itoa(q.MaxTopics)},
+		{"maxlen", strconv.FormatInt(q.MaxLen, 10)},
+		{"rate", strconv.FormatFloat(q.PublishRate, 'f', -1, 64)},
+	})
+}
+
+func namespacePurge(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("namespace purge", flag.ContinueOnError), args, 1, "namespace purge <namespace>")
+	if err != nil {
+		return err
+	}
+
+	n, err := a.admin.PurgeNamespace(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "namespace %s purged, %d keys deleted\n", args[0], n)
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wsk15046/rsq"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// print writes v as json, or rows as a table under header
+func (o *output) print(v interface{}, header []string, rows [][]string) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// jsonMessage is a message with its data printed as text when it is valid utf8
+type jsonMessage struct {
+	EntryId string            `json:"entryId"`
+	Id      string            `json:"id"`
+	TagId   string            `json:"tagId"`
+	Header  map[string]string `json:"header,omitempty"`
+	Data    string            `json:"data"`
+}
+
+func newJsonMessage(m *rsq.Message) *jsonMessage {
+	return &jsonMessage{
+		EntryId: m.EntryId,
+		Id:      m.Id,
+		TagId:   m.TagId,
+		Header:  m.Header,
+		Data:    dataString(m.Data),
+	}
+}
+
+func messageRow(m *rsq.Message) []string {
+	return []string{m.EntryId, m.Id, m.TagId, headerString(m.Header), dataString(m.Data)}
+}
+
+var messageHeader = []string{"ENTRY", "ID", "TAG", "HEADER", "DATA"}
+
+func dataString(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+
+	return fmt.Sprintf("%x", b)
+}
+
+func headerString(h map[string]string) string {
+	kvs := make([]string, 0, len(h))
+	for k, v := range h {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+
+	return strings.Join(kvs, ",")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/wsk15046/rsq/stream"
+	"strconv"
+	"time"
+)
+
+func topicCreate(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("topic create", flag.ContinueOnError)
+	update := fs.Bool("update", false, "replace the config of a registered topic")
+	maxLen := fs.Int64("maxlen", 0, "approximate max length kept by the producers, 0 leaves the trimming to the retention")
+	maxAge := fs.Duration("max-age", 0, "retention by age")
+	retainLen := fs.Int64("retain-len", 0, "retention by length")
+	maxMemory := fs.Int64("max-memory", 0, "retention by bytes used by the topic")
+	safe := fs.Bool("safe", false, "retention never trims the entries not delivered or pending in a group")
+	partitions := fs.Int("partitions", 1, "streams the topic is spread on")
+	codec := fs.String("codec", "", "encoding of the data")
+	maxSize := fs.Int("max-message-size", 0, "max bytes of the data of a message")
+	routing := fs.String("routing", string(stream.RoutingTag), "routing of the messages, tag or broadcast")
+	single := fs.Bool("single-entry", false, "write an entry per message")
+	maxDeliveries := fs.Int64("max-deliveries", 0, "deliveries after which a group dead letters an entry")
+	dlqLen := fs.Int64("dlq-maxlen", 0, "approximate max length of the dead letter queue")
+	retryImmediate := fs.Int("retry-immediate", 0, "in process retries of a failed message")
+	retryDelayed := fs.Int("retry-delayed", 0, "delayed redeliveries of a failed message before it is dead lettered")
+	retryBackoff := fs.Duration("retry-backoff", time.Second, "delay of the first redelivery, doubled for each next one")
+	retryMaxBackoff := fs.Duration("retry-max-backoff", 0, "max delay of a redelivery, 0 for no cap")
+	retryJitter := fs.Float64("retry-jitter", 0, "fraction of the delay randomly added or removed")
+
+	args, err := parseFlags(fs, args, 1, "topic create [-update] [flags] <topic>")
+	if err != nil {
+		return err
+	}
+
+	cfg := &stream.TopicConfig{
+		Name:   args[0],
+		MaxLen: *maxLen,
+		Retention: stream.RetentionPolicy{
+			MaxAge:    *maxAge,
+			MaxLen:    *retainLen,
+			MaxMemory: *maxMemory,
+			Safe:      *safe,
+		},
+		Partitions:     *partitions,
+		Codec:          *codec,
+		MaxMessageSize: *maxSize,
+		Routing:        stream.RoutingMode(*routing),
+		SingleEntry:    *single,
+		DeadLetter:     stream.DeadLetterConfig{MaxDeliveries: *maxDeliveries, MaxLen: *dlqLen},
+		Retry: stream.RetryPolicy{
+			Immediate:  *retryImmediate,
+			Delayed:    *retryDelayed,
+			Backoff:    *retryBackoff,
+			MaxBackoff: *retryMaxBackoff,
+			Jitter:     *retryJitter,
+		},
+	}
+
+	if *update {
+		err = a.reg.UpdateTopic(ctx, cfg)
+	} else {
+		err = a.reg.CreateTopic(ctx, cfg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return a.out.print(cfg, []string{"FIELD", "VALUE"}, configRows(cfg))
+}
+
+func topicDescribe(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("topic describe", flag.ContinueOnError), args, 1, "topic describe <topic>")
+	if err != nil {
+		return err
+	}
+
+	d, err := a.reg.DescribeTopic(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	if d.Config != nil {
+		rows = configRows(d.Config)
+	} else {
+		rows = append(rows, []string{"registered", "false"})
+	}
+
+	if d.Info != nil {
+		rows = append(rows,
+			[]string{"length", strconv.FormatInt(d.Info.Length, 10)},
+			[]string{"groups", strconv.FormatInt(d.Info.Groups, 10)},
+			[]string{"first-entry", d.Info.FirstEntryId},
+			[]string{"last-entry", d.Info.LastEntryId},
+			[]string{"dead-letters", strconv.FormatInt(d.DeadLetters, 10)},
+		)
+	}
+
+	for _, g := range d.Groups {
+		rows = append(rows, []string{"group " + g.Name, fmt.Sprintf("pending %d, lag %d, last delivered %s", g.Pending, g.Lag, g.LastDeliveredId)})
+	}
+
+	return a.out.print(d, []string{"FIELD", "VALUE"}, rows)
+}
+
+func topicDelete(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("topic delete", flag.ContinueOnError), args, 1, "topic delete <topic>")
+	if err != nil {
+		return err
+	}
+
+	if err = a.reg.DeleteTopic(ctx, args[0]); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out.w, "topic %s deleted\n", args[0])
+	return err
+}
+
+func configRows(cfg *stream.TopicConfig) [][]string {
+	return [][]string{
+		{"name", cfg.Name},
+		{"maxlen", strconv.FormatInt(cfg.MaxLen, 10)},
+		{"retention", fmt.Sprintf("max-age %s, maxlen %d, max-memory %d, safe %t", cfg.Retention.MaxAge, cfg.Retention.MaxLen, cfg.Retention.MaxMemory, cfg.Retention.Safe)},
+		{"partitions", strconv.Itoa(cfg.Partitions)},
+		{"codec", cfg.Codec},
+		{"max-message-size", strconv.Itoa(cfg.MaxMessageSize)},
+		{"routing", string(cfg.Routing)},
+		{"single-entry", strconv.FormatBool(cfg.SingleEntry)},
+		{"dead-letter", fmt.Sprintf("max-deliveries %d, maxlen %d", cfg.DeadLetter.MaxDeliveries, cfg.DeadLetter.MaxLen)},
+		{"retry", fmt.Sprintf("immediate %d, delayed %d, backoff %s, max-backoff %s, jitter %g", cfg.Retry.Immediate, cfg.Retry.Delayed, cfg.Retry.Backoff, cfg.Retry.MaxBackoff, cfg.Retry.Jitter)},
+		{"created", cfg.CreatedAt.Format(time.RFC3339)},
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/wsk15046/rsq/stream"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func topicList(ctx context.Context, a *app, args []string) error {
+	pattern := ""
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+
+	topics, err := a.admin.Topics(ctx, pattern)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(topics))
+	for _, t := range topics {
+		rows = append(rows, []string{t})
+	}
+
+	return a.out.print(topics, []string{"TOPIC"}, rows)
+}
+
+func topicInfo(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("topic info", flag.ContinueOnError), args, 1, "topic info <topic>")
+	if err != nil {
+		return err
+	}
+
+	info, err := a.admin.StreamInfo(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{
+		{"length", strconv.FormatInt(info.Length, 10)},
+		{"groups", strconv.FormatInt(info.Groups, 10)},
+		{"entries-added", strconv.FormatInt(info.EntriesAdded, 10)},
+		{"first-entry", info.FirstEntryId},
+		{"last-entry", info.LastEntryId},
+		{"last-generated-id", info.LastGeneratedId},
+		{"max-deleted-id", info.MaxDeletedId},
+	}
+
+	return a.out.print(info, []string{"FIELD", "VALUE"}, rows)
+}
+
+func topicTrim(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("topic trim", flag.ContinueOnError)
+	maxAge := fs.Duration("max-age", 0, "trim the entries older than this age")
+	maxLen := fs.Int64("maxlen", 0, "max length of the topic")
+	maxMemory := fs.Int64("max-memory", 0, "max bytes used by the topic")
+	safe := fs.Bool("safe", false, "never trim the entries not delivered or pending in a group")
+	exact := fs.Bool("exact", false, "trim exactly instead of whole nodes of the stream")
+
+	args, err := parseFlags(fs, args, 1, "topic trim [-max-age d] [-maxlen n] [-max-memory bytes] [-safe] [-exact] <topic>")
+	if err != nil {
+		return err
+	}
+
+	n, err := a.admin.Retain(ctx, args[0], stream.RetentionPolicy{
+		MaxAge:    *maxAge,
+		MaxLen:    *maxLen,
+		MaxMemory: *maxMemory,
+		Safe:      *safe,
+		Exact:     *exact,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.print(map[string]int64{"deleted": n}, []string{"DELETED"}, [][]string{{strconv.FormatInt(n, 10)}})
+}
+
+func tail(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	from := fs.String("from", "$", "entry id to start after, $ for new entries, 0 for all of them")
+
+	args, err := parseFlags(fs, args, 1, "tail [-from id] <topic>")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(a.out.w)
+
+	return a.admin.Tail(ctx, args[0], *from, func(e *stream.Entry) error {
+		for _, m := range e.Messages {
+			if a.out.json {
+				if err := enc.Encode(newJsonMessage(m)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := fmt.Fprintln(a.out.w, strings.Join(messageRow(m), "\t")); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	return headerString(h)
+}
+
+func (h headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("invalid header %s, expect k=v", s)
+	}
+
+	h[k] = v
+	return nil
+}
+
+func publish(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	id := fs.String("id", "", "message id, the line number by default")
+	tags := fs.String("tag", "", "comma separated tags, broadcast by default")
+	maxLen := fs.Int64("maxlen", 10000, "approximate max length of the topic")
+	check := fs.Bool("check", true, "fail when no consumer of the tags is healthy")
+	header := headerFlag{}
+	fs.Var(header, "H", "message header k=v, can be repeated")
+
+	args, err := parseFlags(fs, args, 1, "publish [-id id] [-tag t1,t2] [-H k=v] [-check=false] <topic> < messages")
+	if err != nil {
+		return err
+	}
+
+	var tagIds []string
+	if *tags != "" {
+		tagIds = strings.Split(*tags, ",")
+	}
+
+	p := stream.NewProducer(args[0], *maxLen, a.client, a.log, stream.WithAvailabilityCheck(*check))
+	p.Start()
+	defer p.Stop()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var rows [][]string
+	var entries []map[string]string
+
+	for n := 1; scanner.Scan(); n++ {
+		msgId := *id
+		if msgId == "" {
+			msgId = strconv.Itoa(n)
+		}
+
+		entryId, err := p.PublishSync(ctx, msgId, []byte(scanner.Text()), header, tagIds...)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, []string{entryId, msgId})
+		entries = append(entries, map[string]string{"entryId": entryId, "id": msgId})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	return a.out.print(entries, []string{"ENTRY", "ID"}, rows)
+}
+
+func stats(ctx context.Context, a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("stats", flag.ContinueOnError), args, 1, "stats <topic>")
+	if err != nil {
+		return err
+	}
+
+	m, err := a.admin.Stats(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	rows := make([][]string, 0, len(m))
+	for _, name := range names {
+		s := m[name]
+		rows = append(rows, []string{
+			name,
+			s.TagId,
+			strconv.FormatInt(s.Qps, 10),
+			strconv.FormatInt(s.Lag, 10),
+			strconv.FormatInt(s.Pending, 10),
+			strconv.FormatInt(s.Latency, 10),
+			s.LastReadId,
+			s.UpdateTime.Format(time.RFC3339),
+		})
+	}
+
+	return a.out.print(m, []string{"CONSUMER", "TAG", "QPS", "LAG", "PENDING", "LATENCY(MS)", "LAST-READ", "UPDATED"}, rows)
+}
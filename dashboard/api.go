@@ -0,0 +1,153 @@
+package dashboard
+
+import (
+	"context"
+	"github.com/wsk15046/rsq/stream"
+	"net/http"
+	"time"
+)
+
+type topicSummary struct {
+	Name   string `json:"name"`
+	Length int64  `json:"length"`
+}
+
+func (h *Handler) topics(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	topics, e := h.admin.Topics(ctx, h.pattern)
+	if e != nil {
+		return nil, e
+	}
+
+	summaries := make([]*topicSummary, 0, len(topics))
+	for _, t := range topics {
+		n, e := h.admin.Len(ctx, t)
+		if e != nil {
+			return nil, e
+		}
+
+		summaries = append(summaries, &topicSummary{Name: t, Length: n})
+	}
+
+	return summaries, nil
+}
+
+func (h *Handler) topic(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	return h.admin.StreamInfo(ctx, params[0])
+}
+
+func (h *Handler) stats(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	stats, e := h.admin.Stats(ctx, params[0])
+	if e != nil {
+		return nil, e
+	}
+
+	controls, e := h.admin.Controls(ctx, params[0])
+	if e != nil {
+		return nil, e
+	}
+
+	return map[string]interface{}{"consumers": stats, "controls": controls}, nil
+}
+
+func (h *Handler) groups(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	return h.admin.Groups(ctx, params[0])
+}
+
+func (h *Handler) consumers(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	return h.admin.Consumers(ctx, params[0], params[1])
+}
+
+func (h *Handler) pending(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	consumer := r.URL.Query().Get("consumer")
+	return h.admin.Pending(ctx, params[0], params[1], consumer, queryInt(r, "count", 100))
+}
+
+func (h *Handler) deadLetters(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	return h.admin.DeadLetters(ctx, params[0], r.URL.Query().Get("from"), queryInt(r, "count", 100))
+}
+
+func (h *Handler) redrive(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	var req struct {
+		Ids    []string `json:"ids"`    //all the dead letters when empty
+		MaxLen int64    `json:"maxLen"` //approximate max length the topic is trimmed to, 0 for no trim
+		Group  bool     `json:"group"`  //to the group which dead lettered each entry, to every group of the topic otherwise
+	}
+
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+
+	var n int64
+	var e error
+	if req.Group {
+		n, e = h.admin.RedriveGroup(ctx, params[0], req.Ids...)
+	} else {
+		n, e = h.admin.Redrive(ctx, params[0], req.MaxLen, req.Ids...)
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	return map[string]int64{"redriven": n}, nil
+}
+
+func (h *Handler) reset(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	var req struct {
+		Id string `json:"id"`
+	}
+
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+
+	if req.Id == "" {
+		return nil, &httpError{code: http.StatusBadRequest, msg: "id is required"}
+	}
+
+	if e := h.admin.ResetGroup(ctx, params[0], params[1], req.Id); e != nil {
+		return nil, e
+	}
+
+	return map[string]string{"group": params[1], "id": req.Id}, nil
+}
+
+func (h *Handler) pause(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if e := h.admin.PauseConsumer(ctx, params[0], params[1]); e != nil {
+		return nil, e
+	}
+
+	return map[string]interface{}{"consumer": params[1], "paused": true}, nil
+}
+
+func (h *Handler) resume(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if e := h.admin.ResumeConsumer(ctx, params[0], params[1]); e != nil {
+		return nil, e
+	}
+
+	return map[string]interface{}{"consumer": params[1], "paused": false}, nil
+}
+
+func (h *Handler) seek(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	var req struct {
+		Id   string    `json:"id"`
+		Time time.Time `json:"time"`
+	}
+
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+
+	if req.Id == "" && !req.Time.IsZero() {
+		req.Id = stream.SeekId(req.Time)
+	}
+
+	if req.Id == "" {
+		return nil, &httpError{code: http.StatusBadRequest, msg: "id or time is required"}
+	}
+
+	if e := h.admin.SeekConsumer(ctx, params[0], params[1], req.Id); e != nil {
+		return nil, e
+	}
+
+	return map[string]string{"consumer": params[1], "seek": req.Id}, nil
+}
@@ -0,0 +1,253 @@
+// Package dashboard serves the stat, groups, pending entries and dead letters of rsq topics
+// as json endpoints together with a small html dashboard.
+//
+//	mux.Handle("/rsq/", http.StripPrefix("/rsq", dashboard.NewHandler(client, logger, dashboard.WithAuth(auth))))
+package dashboard
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/stream"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed index.html
+var indexHtml []byte
+
+const (
+	ActionRedrive = "redrive"
+	ActionReset   = "reset"
+	ActionPause   = "pause"
+	ActionResume  = "resume"
+	ActionSeek    = "seek"
+)
+
+// Authorizer allows an action on a topic, actions are refused when no Authorizer is set
+type Authorizer func(r *http.Request, action, topic string) error
+
+type Option func(h *Handler)
+
+// WithAuth gates the actions changing topics behind auth
+func WithAuth(auth Authorizer) Option {
+	return func(h *Handler) {
+		h.auth = auth
+	}
+}
+
+// WithTopicPattern limits the listed topics to the keys matching pattern
+func WithTopicPattern(pattern string) Option {
+	return func(h *Handler) {
+		h.pattern = pattern
+	}
+}
+
+type Handler struct {
+	rsq.ILogger
+
+	client  redis.UniversalClient
+	admin   *stream.Admin
+	auth    Authorizer
+	pattern string
+	timeout time.Duration
+}
+
+type route struct {
+	method  string
+	pattern []string // "{}" matches any segment
+	action  string   // not empty for the routes gated by auth
+	serve   func(ctx context.Context, r *http.Request, params []string) (interface{}, error)
+}
+
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+var errNotFound = &httpError{code: http.StatusNotFound, msg: "not found"}
+
+func NewHandler(cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Handler {
+	h := &Handler{
+		ILogger: l,
+		client:  cl,
+		admin:   stream.NewAdmin(cl, l),
+		timeout: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+func (h *Handler) routes() []*route {
+	return []*route{
+		{method: http.MethodGet, pattern: []string{"api", "topics"}, serve: h.topics},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}"}, serve: h.topic},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}", "stats"}, serve: h.stats},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}", "groups"}, serve: h.groups},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}", "groups", "{}", "consumers"}, serve: h.consumers},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}", "groups", "{}", "pending"}, serve: h.pending},
+		{method: http.MethodGet, pattern: []string{"api", "topics", "{}", "dlq"}, serve: h.deadLetters},
+		{method: http.MethodPost, pattern: []string{"api", "topics", "{}", "dlq", "redrive"}, action: ActionRedrive, serve: h.redrive},
+		{method: http.MethodPost, pattern: []string{"api", "topics", "{}", "groups", "{}", "reset"}, action: ActionReset, serve: h.reset},
+		{method: http.MethodPost, pattern: []string{"api", "topics", "{}", "consumers", "{}", "pause"}, action: ActionPause, serve: h.pause},
+		{method: http.MethodPost, pattern: []string{"api", "topics", "{}", "consumers", "{}", "resume"}, action: ActionResume, serve: h.resume},
+		{method: http.MethodPost, pattern: []string{"api", "topics", "{}", "consumers", "{}", "seek"}, action: ActionSeek, serve: h.seek},
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.EscapedPath(), "/")
+
+	switch path {
+	case "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(indexHtml)
+		return
+	case "health":
+		h.health(w, r)
+		return
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if v, e := url.PathUnescape(s); e == nil {
+			segments[i] = v
+		}
+	}
+
+	rt, params, allowed := h.match(r.Method, segments)
+	if rt == nil {
+		if allowed {
+			writeError(w, &httpError{code: http.StatusMethodNotAllowed, msg: "method not allowed"})
+		} else {
+			writeError(w, errNotFound)
+		}
+		return
+	}
+
+	if rt.action != "" {
+		if h.auth == nil {
+			writeError(w, &httpError{code: http.StatusForbidden, msg: "actions are disabled"})
+			return
+		}
+
+		if e := h.auth(r, rt.action, params[0]); e != nil {
+			writeError(w, &httpError{code: http.StatusForbidden, msg: e.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	v, e := rt.serve(ctx, r, params)
+	if e != nil {
+		if rt.action != "" {
+			h.Errorf("dashboard %s %s failed: %s", rt.action, path, e)
+		}
+		writeError(w, e)
+		return
+	}
+
+	if rt.action != "" {
+		h.Infof("dashboard %s %s done", rt.action, path)
+	}
+
+	writeJson(w, http.StatusOK, v)
+}
+
+// match returns the route and its parameters, allowed is true when the path exists for another method
+func (h *Handler) match(method string, segments []string) (rt *route, params []string, allowed bool) {
+	for _, candidate := range h.routes() {
+		if len(candidate.pattern) != len(segments) {
+			continue
+		}
+
+		var ps []string
+		matched := true
+		for i, p := range candidate.pattern {
+			if p == "{}" {
+				ps = append(ps, segments[i])
+			} else if p != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if !matched {
+			continue
+		}
+
+		if candidate.method != method {
+			allowed = true
+			continue
+		}
+
+		return candidate, ps, false
+	}
+
+	return nil, nil, allowed
+}
+
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	if e := h.client.Ping(ctx).Err(); e != nil {
+		writeJson(w, http.StatusServiceUnavailable, map[string]string{"status": "down", "error": e.Error()})
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, e error) {
+	code := http.StatusInternalServerError
+
+	var he *httpError
+	if errors.As(e, &he) {
+		code = he.code
+	} else if errors.Is(e, redis.Nil) {
+		code = http.StatusNotFound
+	}
+
+	writeJson(w, code, map[string]string{"error": e.Error()})
+}
+
+func queryInt(r *http.Request, key string, def int64) int64 {
+	if v, e := strconv.ParseInt(r.URL.Query().Get(key), 10, 64); e == nil && v > 0 {
+		return v
+	}
+
+	return def
+}
+
+// decodeBody decodes the json body into v, an empty body is allowed
+func decodeBody(r *http.Request, v interface{}) error {
+	if e := json.NewDecoder(r.Body).Decode(v); e != nil && !errors.Is(e, io.EOF) {
+		return &httpError{code: http.StatusBadRequest, msg: e.Error()}
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package dashboard
+
+import (
+	"errors"
+	"github.com/wsk15046/rsq/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerRoutes(t *testing.T) {
+	c, l := test.Dependency()
+
+	denied := errors.New("denied")
+	var gotAction, gotTopic string
+
+	cases := []struct {
+		h      *Handler
+		method string
+		path   string
+		code   int
+	}{
+		{NewHandler(c, l), http.MethodGet, "/", http.StatusOK},
+		{NewHandler(c, l), http.MethodGet, "/api/unknown", http.StatusNotFound},
+		{NewHandler(c, l), http.MethodPost, "/api/topics", http.StatusMethodNotAllowed},
+		{NewHandler(c, l), http.MethodPost, "/api/topics/rsq%3Atest/dlq/redrive", http.StatusForbidden},
+		{NewHandler(c, l, WithAuth(func(r *http.Request, action, topic string) error {
+			gotAction, gotTopic = action, topic
+			return denied
+		})), http.MethodPost, "/api/topics/rsq%3Atest/consumers/group@g@n/pause", http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		tc.h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+
+		if w.Code != tc.code {
+			t.Errorf("%s %s expect %d, got %d %s", tc.method, tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+
+	if gotAction != ActionPause || gotTopic != "rsq:test" {
+		t.Errorf("unexpected auth call %s %s", gotAction, gotTopic)
+	}
+}
@@ -0,0 +1,370 @@
+// Package gateway exposes rsq topics over http for services which can't use the go client.
+//
+//	POST /topics/{topic}/messages                                      publish a message
+//	GET  /topics/{topic}/groups/{group}/messages?consumer=c&count=n&wait=5s   long poll
+//	GET  /topics/{topic}/groups/{group}/events?consumer=c               server-sent events
+//	POST /topics/{topic}/groups/{group}/ack?consumer=c                  ack entries {"entryIds": [...]}
+//
+// A poll returns up to count messages, 100 by default and 1000 at most, and the event stream sends them
+// as they come. Both leave them pending, the entries not acked within the ack timeout are handed to the next
+// poll or event stream of the group. Every request goes through the Authorizer with its http request,
+// those it refuses or made without one are answered 403.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/internal/edge"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/stream"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ActionPublish = edge.ActionPublish
+	ActionConsume = edge.ActionConsume
+)
+
+// maxCount caps the messages returned by a poll
+const maxCount = 1000
+
+// Authorizer allows an action on a topic, requests are refused when no Authorizer is set
+type Authorizer func(r *http.Request, action, topic string) error
+
+// AllowTopics allows every action on the topics matching one of patterns, see path.Match
+func AllowTopics(patterns ...string) Authorizer {
+	return func(r *http.Request, action, topic string) error {
+		return edge.MatchTopic(patterns, topic)
+	}
+}
+
+type Option func(gw *Gateway)
+
+// WithAuth gates the requests behind auth
+func WithAuth(auth Authorizer) Option {
+	return func(gw *Gateway) {
+		gw.auth = auth
+	}
+}
+
+// WithMaxGroups sets the max group consumers kept by the gateway, and how long an unused one is kept
+func WithMaxGroups(n int, idle time.Duration) Option {
+	return func(gw *Gateway) {
+		gw.cfg.MaxGroups = n
+		gw.cfg.GroupIdle = idle
+	}
+}
+
+// WithMaxLen sets the max length of the topics written by the gateway
+func WithMaxLen(n int64) Option {
+	return func(gw *Gateway) {
+		gw.cfg.MaxLen = n
+	}
+}
+
+// WithAckTimeout sets how long a delivered entry may stay unacked before it is delivered again
+func WithAckTimeout(d time.Duration) Option {
+	return func(gw *Gateway) {
+		gw.cfg.AckTimeout = d
+	}
+}
+
+// WithMaxInflight sets the max unacked entries of a consumer, the event stream waits below it
+func WithMaxInflight(n int64) Option {
+	return func(gw *Gateway) {
+		gw.cfg.MaxInflight = n
+	}
+}
+
+// WithStreamOptions sets the options of the producers and groups created by the gateway
+func WithStreamOptions(opts ...stream.Option) Option {
+	return func(gw *Gateway) {
+		gw.cfg.StreamOpts = opts
+	}
+}
+
+type Gateway struct {
+	rsq.ILogger
+
+	cfg     edge.Config
+	maxWait time.Duration
+	auth    Authorizer
+	topics  *edge.Topics
+}
+
+func New(cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Gateway {
+	gw := &Gateway{
+		ILogger: l,
+
+		cfg:     edge.DefaultConfig(),
+		maxWait: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(gw)
+	}
+
+	gw.topics = edge.NewTopics(cl, l, gw.cfg)
+
+	return gw
+}
+
+// serveGroup serves a request of a group consumer with the group taken from the pool
+func (gw *Gateway) serveGroup(w http.ResponseWriter, r *http.Request, topic, group, consumer string,
+	serve func(w http.ResponseWriter, r *http.Request, g *stream.Group)) {
+	g, e := gw.topics.Groups().Acquire(topic, group, consumer)
+	if e != nil {
+		writeError(w, http.StatusServiceUnavailable, e)
+		return
+	}
+	defer gw.topics.Groups().Release(g)
+
+	serve(w, r, g)
+}
+
+// authorize writes a forbidden error when the action isn't allowed on topic
+func (gw *Gateway) authorize(w http.ResponseWriter, r *http.Request, action, topic string) bool {
+	if gw.auth == nil {
+		writeError(w, http.StatusForbidden, errors.New("no authorizer"))
+		return false
+	}
+
+	if e := gw.auth(r, action, topic); e != nil {
+		writeError(w, http.StatusForbidden, e)
+		return false
+	}
+
+	return true
+}
+
+// Close stops the producers and the groups started by the gateway
+func (gw *Gateway) Close() {
+	gw.topics.Close()
+}
+
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i, s := range segments {
+		if v, e := url.PathUnescape(s); e == nil {
+			segments[i] = v
+		}
+	}
+
+	switch {
+	case len(segments) == 3 && segments[0] == "topics" && segments[2] == "messages":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+
+		if gw.authorize(w, r, ActionPublish, segments[1]) {
+			gw.publish(w, r, segments[1])
+		}
+
+	case len(segments) == 5 && segments[0] == "topics" && segments[2] == "groups":
+		topic, group := segments[1], segments[3]
+
+		consumer := r.URL.Query().Get("consumer")
+		if consumer == "" {
+			writeError(w, http.StatusBadRequest, errors.New("consumer is required"))
+			return
+		}
+
+		var serve func(w http.ResponseWriter, r *http.Request, g *stream.Group)
+		switch {
+		case segments[4] == "messages" && r.Method == http.MethodGet:
+			serve = gw.poll
+		case segments[4] == "events" && r.Method == http.MethodGet:
+			serve = gw.events
+		case segments[4] == "ack" && r.Method == http.MethodPost:
+			serve = gw.ack
+		default:
+			writeError(w, http.StatusNotFound, errors.New("not found"))
+			return
+		}
+
+		if gw.authorize(w, r, ActionConsume, topic) {
+			gw.serveGroup(w, r, topic, group, consumer, serve)
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+type publishRequest struct {
+	Id       string            `json:"id"`
+	Data     string            `json:"data"`
+	Encoding string            `json:"encoding,omitempty"` //"base64" or empty for text
+	Tags     []string          `json:"tags,omitempty"`     //broadcast when empty
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+func (gw *Gateway) publish(w http.ResponseWriter, r *http.Request, topic string) {
+	var req publishRequest
+	if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+		writeError(w, http.StatusBadRequest, e)
+		return
+	}
+
+	data, e := decodeData(req.Data, req.Encoding)
+	if e != nil {
+		writeError(w, http.StatusBadRequest, e)
+		return
+	}
+
+	if e = edge.ValidateMessage(req.Id, req.Tags); e != nil {
+		writeError(w, http.StatusBadRequest, e)
+		return
+	}
+
+	entryId, e := gw.topics.Producer(topic).PublishSync(r.Context(), req.Id, data, req.Headers, req.Tags...)
+	if e != nil {
+		var ke *kerror.KError
+		if errors.As(e, &ke) && ke.Code() == kerror.UnavailableError.Code() {
+			writeError(w, http.StatusServiceUnavailable, e)
+			return
+		}
+
+		writeError(w, http.StatusInternalServerError, e)
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]string{"entryId": entryId})
+}
+
+// next returns the messages idle past the ack timeout, or new ones
+func (gw *Gateway) next(ctx context.Context, g *stream.Group, count int64, wait time.Duration) ([]*rsq.Message, error) {
+	msgs, e := g.ClaimIdle(ctx, gw.cfg.AckTimeout, count)
+	if e != nil || len(msgs) > 0 {
+		return msgs, e
+	}
+
+	return g.Fetch(ctx, count, wait)
+}
+
+func (gw *Gateway) poll(w http.ResponseWriter, r *http.Request, g *stream.Group) {
+	count := int64(100)
+	if v := r.URL.Query().Get("count"); v != "" {
+		if _, e := fmt.Sscan(v, &count); e != nil || count <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid count"))
+			return
+		}
+	}
+
+	if count > maxCount {
+		count = maxCount
+	}
+
+	wait := time.Duration(0)
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var e error
+		if wait, e = time.ParseDuration(v); e != nil || wait < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid wait"))
+			return
+		}
+	}
+
+	if wait > gw.maxWait {
+		wait = gw.maxWait
+	}
+
+	// a zero wait must not block forever
+	if wait == 0 {
+		wait = time.Millisecond
+	}
+
+	msgs, e := gw.next(r.Context(), g, count, wait)
+	if e != nil {
+		writeError(w, http.StatusInternalServerError, e)
+		return
+	}
+
+	out := make([]*message, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, newMessage(m))
+	}
+
+	writeJson(w, http.StatusOK, out)
+}
+
+func (gw *Gateway) events(w http.ResponseWriter, r *http.Request, g *stream.Group) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+
+	for ctx.Err() == nil {
+		inflight, e := g.Pending(ctx)
+		if e != nil {
+			gw.Errorf("gateway inflight failed topic: %s, err: %s", g.Topic(), e)
+		}
+
+		if inflight >= gw.cfg.MaxInflight {
+			// wait for acks, a comment keeps the connection alive
+			_, _ = io.WriteString(w, ": waiting for acks\n\n")
+			flusher.Flush()
+			time.Sleep(time.Second)
+			continue
+		}
+
+		msgs, e := gw.next(ctx, g, gw.cfg.MaxInflight-inflight, 5*time.Second)
+		if e != nil {
+			if ctx.Err() == nil {
+				gw.Errorf("gateway events failed topic: %s, err: %s", g.Topic(), e)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		if len(msgs) == 0 {
+			_, _ = io.WriteString(w, ": keepalive\n\n")
+			flusher.Flush()
+			continue
+		}
+
+		for _, m := range msgs {
+			b, _ := json.Marshal(newMessage(m))
+			if _, e = fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", m.EntryId, b); e != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (gw *Gateway) ack(w http.ResponseWriter, r *http.Request, g *stream.Group) {
+	var req struct {
+		EntryIds []string `json:"entryIds"`
+	}
+
+	if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+		writeError(w, http.StatusBadRequest, e)
+		return
+	}
+
+	n, e := g.Ack(r.Context(), req.EntryIds...)
+	if e != nil {
+		writeError(w, http.StatusInternalServerError, e)
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]int64{"acked": n})
+}
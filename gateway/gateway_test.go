@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGatewayRequests(t *testing.T) {
+	c, l := test.Dependency()
+
+	gw := New(c, l, WithAuth(AllowTopics("rsq:gw_*")))
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodGet, "/topics/rsq:gw_test/messages", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/topics/rsq:gw_test/messages", "{", http.StatusBadRequest},
+		{http.MethodPost, "/topics/rsq:gw_test/messages", `{"id":"a-b","data":"x"}`, http.StatusBadRequest},
+		{http.MethodPost, "/topics/rsq:gw_test/messages", `{"id":"1","data":"x","tags":["a-b"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/topics/rsq:gw_test/messages", `{"id":"1","data":"x","encoding":"hex"}`, http.StatusBadRequest},
+		{http.MethodGet, "/topics/rsq:gw_test/groups/g1/messages", "", http.StatusBadRequest},
+		{http.MethodPost, "/topics/rsq:other/messages", `{"id":"1","data":"x"}`, http.StatusForbidden},
+		{http.MethodGet, "/topics/rsq:other/groups/g1/messages?consumer=c", "", http.StatusForbidden},
+		{http.MethodGet, "/unknown", "", http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+
+		if w.Code != tc.code {
+			t.Errorf("%s %s expect %d, got %d %s", tc.method, tc.path, tc.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGatewayWithoutAuth(t *testing.T) {
+	c, l := test.Dependency()
+
+	w := httptest.NewRecorder()
+	New(c, l).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/topics/rsq:gw_test/messages", strings.NewReader(`{"id":"1","data":"x"}`)))
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expect requests refused without authorizer, got %d", w.Code)
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	for _, data := range [][]byte{[]byte("text"), {0xff, 0x00}} {
+		m := newMessage(&rsq.Message{Data: data})
+
+		b, e := decodeData(m.Data, m.Encoding)
+		if e != nil || string(b) != string(data) {
+			t.Errorf("expect %v, got %v %v", data, b, e)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/wsk15046/rsq"
+	"net/http"
+	"unicode/utf8"
+)
+
+const encodingBase64 = "base64"
+
+type message struct {
+	Topic         string            `json:"topic"`
+	Id            string            `json:"id"`
+	TagId         string            `json:"tagId"`
+	EntryId       string            `json:"entryId"`
+	DeliveryCount int64             `json:"deliveryCount"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Data          string            `json:"data"`
+	Encoding      string            `json:"encoding,omitempty"`
+}
+
+// newMessage encodes data in base64 when it isn't valid utf8
+func newMessage(m *rsq.Message) *message {
+	out := &message{
+		Topic:         m.Topic,
+		Id:            m.Id,
+		TagId:         m.TagId,
+		EntryId:       m.EntryId,
+		DeliveryCount: m.DeliveryCount,
+		Headers:       m.Header,
+	}
+
+	if utf8.Valid(m.Data) {
+		out.Data = string(m.Data)
+	} else {
+		out.Data = base64.StdEncoding.EncodeToString(m.Data)
+		out.Encoding = encodingBase64
+	}
+
+	return out
+}
+
+func decodeData(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(data), nil
+	case encodingBase64:
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	return nil, fmt.Errorf("unknown encoding %s", encoding)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, e error) {
+	writeJson(w, code, map[string]string{"error": e.Error()})
+}
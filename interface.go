@@ -1,11 +1,50 @@
 package rsq
 
+import "context"
+
 type ConsumerHandler func(id string, data []byte, h IMQConsumer)
 
+// MessageHandler receives a message together with its headers and delivery metadata.
+// ctx carries the span started around the call, a returned error is recorded on it.
+type MessageHandler func(ctx context.Context, msg *Message, h IMQConsumer) error
+
+// BatchHandler receives the messages read together, it returns nil when all of them are handled or an error per message.
+// A group acks an entry once all its messages are handled, see stream.WithHandlerBatch.
+type BatchHandler func(ctx context.Context, msgs []*Message, h IMQConsumer) []error
+
+type Message struct {
+	Id     string
+	TagId  string
+	Data   []byte
+	Header map[string]string
+
+	Topic         string
+	EntryId       string //id of the stream entry carrying the message
+	Batched       bool   //other messages of the consumer share the entry, acking it acks them too
+	DeliveryCount int64
+	Attempt       int //calls of the handler with the message, this one included
+}
+
+// ProducerStats shows how saturated the buffer of a producer is
+type ProducerStats struct {
+	Buffered  int   //messages waiting in the buffer
+	Capacity  int   //size of the buffer
+	Published int64 //messages written to the stream
+	Failed    int64 //messages lost by failed writes
+	Full      int64 //publishes which found the buffer full
+	Dropped   int64 //buffered messages dropped for newer ones
+	Rejected  int64 //publishes failed because the buffer stayed full
+	Throttled int64 //publishes delayed by the rate limit
+}
+
 type IMQProducer interface {
 	Topic() string
 	Start()
 	Publish(id string, data []byte, tagId ...string) (err error)
+	PublishCtx(ctx context.Context, id string, data []byte, header map[string]string, tagId ...string) (err error)
+	// PublishSync writes the message before returning, the id of the stream entry is returned
+	PublishSync(ctx context.Context, id string, data []byte, header map[string]string, tagId ...string) (entryId string, err error)
+	Stats() ProducerStats
 	Stop()
 }
 
@@ -15,6 +54,14 @@ type IMQConsumer interface {
 	TagId() string
 	Subscribe()
 	SetHandler(h ConsumerHandler)
+	SetMessageHandler(h MessageHandler)
+	SetBatchHandler(h BatchHandler)
+	// Pause stops reading messages until Resume, the messages already read are still handled
+	Pause()
+	Resume()
+	// Seek moves the consumer so that the next messages read follow the entry id, "0" from the start, "$" from the end.
+	// A group moves for all its consumers.
+	Seek(id string) error
 	Stop()
 }
 
@@ -0,0 +1,123 @@
+// Package edge holds what the servers of rsq topics for remote clients share, the http gateway and the grpc server:
+// the actions checked by their authorizers, the validation of the published messages,
+// and the producers and groups they publish and pull with.
+package edge
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/stream"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ActionPublish = "publish"
+	ActionConsume = "consume"
+)
+
+// Config is the configuration shared by the servers
+type Config struct {
+	MaxLen      int64 //max length of the topics written by the server
+	AckTimeout  time.Duration
+	MaxInflight int64
+	MaxGroups   int
+	GroupIdle   time.Duration
+	StreamOpts  []stream.Option
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxLen:      10000,
+		AckTimeout:  time.Minute,
+		MaxInflight: 1000,
+		MaxGroups:   1000,
+		GroupIdle:   10 * time.Minute,
+	}
+}
+
+// MatchTopic fails unless topic matches one of patterns, see path.Match
+func MatchTopic(patterns []string, topic string) error {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, topic); ok {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("topic %s not allowed", topic)
+}
+
+// ValidateMessage checks the id and the tags of a message to publish,
+// they are joined with '-' in the fields of an entry so they can't contain it.
+func ValidateMessage(id string, tags []string) error {
+	if id == "" || strings.Contains(id, "-") {
+		return errors.New("id is required and can't contain '-'")
+	}
+
+	for _, tag := range tags {
+		if tag == "" || strings.Contains(tag, "-") {
+			return fmt.Errorf("invalid tag %q, a tag can't be empty nor contain '-'", tag)
+		}
+	}
+
+	return nil
+}
+
+// Topics keeps the producers and the groups of the topics served
+type Topics struct {
+	rsq.ILogger
+
+	client    redis.UniversalClient
+	cfg       Config
+	producers map[string]rsq.IMQProducer
+	groups    *stream.GroupPool
+	mutex     sync.Mutex
+}
+
+func NewTopics(cl redis.UniversalClient, l rsq.ILogger, cfg Config) *Topics {
+	return &Topics{
+		ILogger: l,
+
+		client:    cl,
+		cfg:       cfg,
+		producers: make(map[string]rsq.IMQProducer),
+		groups:    stream.NewGroupPool(cfg.MaxGroups, cfg.GroupIdle, cl, l, cfg.StreamOpts...),
+	}
+}
+
+// Producer returns the started producer of topic
+func (t *Topics) Producer(topic string) rsq.IMQProducer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	p, ok := t.producers[topic]
+	if !ok {
+		p = stream.NewProducer(topic, t.cfg.MaxLen, t.client, t.ILogger, t.cfg.StreamOpts...)
+		p.Start()
+		t.producers[topic] = p
+	}
+
+	return p
+}
+
+// Groups returns the pool of the groups of the remote consumers
+func (t *Topics) Groups() *stream.GroupPool {
+	return t.groups
+}
+
+// Close stops the producers and the groups
+func (t *Topics) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for topic, p := range t.producers {
+		p.Stop()
+		delete(t.producers, topic)
+	}
+
+	t.groups.Close()
+}
@@ -0,0 +1,36 @@
+package edge
+
+import (
+	"testing"
+)
+
+func TestValidateMessage(t *testing.T) {
+	cases := []struct {
+		id    string
+		tags  []string
+		valid bool
+	}{
+		{"1", nil, true},
+		{"1", []string{"a", "$"}, true},
+		{"", nil, false},
+		{"a-b", nil, false},
+		{"1", []string{"a-b"}, false},
+		{"1", []string{""}, false},
+	}
+
+	for _, tc := range cases {
+		if e := ValidateMessage(tc.id, tc.tags); (e == nil) != tc.valid {
+			t.Errorf("%q %v expect valid %t, got %v", tc.id, tc.tags, tc.valid, e)
+		}
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	patterns := []string{"orders.*", "rsq:t"}
+
+	for topic, allowed := range map[string]bool{"orders.eu": true, "rsq:t": true, "rsq:other": false, "orders": false} {
+		if e := MatchTopic(patterns, topic); (e == nil) != allowed {
+			t.Errorf("%s expect allowed %t, got %v", topic, allowed, e)
+		}
+	}
+}
@@ -32,7 +32,7 @@ func (e *KError) Msg(msg string) *KError {
 func (e *KError) Msgf(format string, a ...any) *KError {
 	return &KError{
 		code: e.code,
-		msg:  fmt.Sprintf(format, a),
+		msg:  fmt.Sprintf(format, a...),
 	}
 }
 
@@ -47,4 +47,18 @@ var (
 	SystemError = NewKError(603, "system error")
 	RedisError  = NewKError(604, "redis error")
 	JsonError   = NewKError(609, "json error")
+
+	UnavailableError = NewKError(610, "no available consumer")
+	LockedError      = NewKError(611, "lock held by another owner")
+	LockLostError    = NewKError(612, "lock not held")
+	QueueFullError   = NewKError(613, "producer queue full")
+
+	TopicExistsError     = NewKError(614, "topic already registered")
+	TopicNotFoundError   = NewKError(615, "topic not registered")
+	MessageTooLargeError = NewKError(616, "message too large")
+	QuotaExceededError   = NewKError(617, "quota exceeded")
+	RateLimitedError     = NewKError(619, "publish rate limited")
+
+	// PermanentError returned by a handler dead letters the message without retrying it
+	PermanentError = NewKError(618, "permanent error")
 )
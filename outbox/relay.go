@@ -0,0 +1,324 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/redisop"
+	"github.com/wsk15046/rsq/stream"
+	"sync"
+	"time"
+)
+
+type Option func(r *Relay)
+
+// WithBatchSize sets the max rows read at once
+func WithBatchSize(n int) Option {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithInterval sets the wait between two reads of the store when it has no unsent row
+func WithInterval(d time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = d
+	}
+}
+
+// WithLease sets the key and the ttl of the lease held by the active relay
+func WithLease(key string, ttl time.Duration) Option {
+	return func(r *Relay) {
+		r.leaseKey = key
+		r.leaseTTL = ttl
+	}
+}
+
+// WithDedupeTTL sets how long a published message is remembered so that it isn't published twice
+func WithDedupeTTL(d time.Duration) Option {
+	return func(r *Relay) {
+		r.dedupeTTL = d
+	}
+}
+
+// WithMaxLen sets the max length of the topics written by the relay
+func WithMaxLen(n int64) Option {
+	return func(r *Relay) {
+		r.maxLen = n
+	}
+}
+
+// WithMaxAttempts sets the failed publishes of a record after which it is moved to the dead letter queue of its topic,
+// so that it stops blocking the topic. 0 retries it until it is published.
+func WithMaxAttempts(n int) Option {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithNamespace publishes the records to the topics of the namespace ns, the lease and the dedupe keys are in ns too
+func WithNamespace(ns string) Option {
+	return func(r *Relay) {
+		r.namespace = ns
+	}
+}
+
+// WithStreamOptions sets the options of the producers, the relay publishes without checking the consumers by default
+func WithStreamOptions(opts ...stream.Option) Option {
+	return func(r *Relay) {
+		r.streamOpts = append(r.streamOpts, opts...)
+	}
+}
+
+// Relay publishes the unsent records of a store.
+// The records of a topic are published in their order, a failed record blocks the next ones until it is published,
+// or until it is dead lettered after the max attempts. A dead lettered record is marked sent with the id of its
+// dead letter prefixed with "dlq:", see Admin.DeadLetters and Admin.Redrive.
+type Relay struct {
+	rsq.ILogger
+
+	store       Store
+	client      redis.UniversalClient
+	name        string
+	batchSize   int
+	interval    time.Duration
+	leaseKey    string
+	leaseTTL    time.Duration
+	dedupeTTL   time.Duration
+	maxLen      int64
+	maxAttempts int
+	namespace   string
+	streamOpts  []stream.Option
+
+	election  *redisop.Election
+	admin     *stream.Admin
+	cursor    int64           //id the next batch is read after, the records before it are sent or blocked
+	blocked   map[string]bool //topics with a failed record since the cursor left 0
+	attempts  map[int64]int   //failed publishes of the records, counted by the active relay
+	producers map[string]rsq.IMQProducer
+	quit      chan bool
+	done      chan bool
+	once      sync.Once
+}
+
+// NewRelay creates a relay, name identifies it in the logs
+func NewRelay(store Store, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Relay {
+	r := &Relay{
+		ILogger: l,
+
+		store:       store,
+		client:      cl,
+		name:        name,
+		batchSize:   100,
+		interval:    time.Second,
+		leaseKey:    "rsq_outbox_relay",
+		leaseTTL:    10 * time.Second,
+		dedupeTTL:   24 * time.Hour,
+		maxLen:      10000,
+		maxAttempts: 10,
+		streamOpts:  []stream.Option{stream.WithAvailabilityCheck(false)},
+		admin:       stream.NewAdmin(cl, l),
+		producers:   make(map[string]rsq.IMQProducer),
+		blocked:     make(map[string]bool),
+		attempts:    make(map[int64]int),
+		quit:        make(chan bool),
+		done:        make(chan bool),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.election = redisop.NewElection(cl, l, stream.NamespaceKey(r.namespace, r.leaseKey), r.leaseTTL)
+	r.election.OnElected(func() {
+		r.Infof("outbox relay %s is active", r.name)
+	})
+	r.election.OnRevoked(func() {
+		r.Infof("outbox relay %s lost the lease", r.name)
+	})
+
+	return r
+}
+
+// Start campaigns for the lease and publishes the unsent records while it is held
+func (r *Relay) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	campaign := make(chan bool)
+
+	go func() {
+		defer close(campaign)
+		r.election.Run(ctx)
+	}()
+
+	go func() {
+		defer close(r.done)
+
+		for {
+			sent := 0
+			if r.election.Leader() {
+				sent = r.relay()
+			}
+
+			//a batch with a blocked topic publishes less than the batch size and waits
+			wait := r.interval
+			if sent == r.batchSize {
+				wait = 0
+			}
+
+			select {
+			case <-r.quit:
+				//the lease is given up once the campaign is over
+				cancel()
+				<-campaign
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop waits for the relay to finish its batch and gives up the lease
+func (r *Relay) Stop() {
+	r.once.Do(func() {
+		close(r.quit)
+		<-r.done
+
+		for topic, p := range r.producers {
+			p.Stop()
+			delete(r.producers, topic)
+		}
+	})
+}
+
+// relay publishes a batch of unsent records and returns the number of published ones.
+// The records of a blocked topic are skipped, the next batches read past them so that the other topics go on,
+// and the blocked topics are retried once the cursor gets back to the first record.
+func (r *Relay) relay() int {
+	ctx := context.Background()
+
+	records, e := r.store.Unsent(ctx, r.cursor, r.batchSize)
+	if e != nil {
+		r.Errorf("outbox relay read failed: %s", e)
+		return 0
+	}
+
+	sent := 0
+	for _, rec := range records {
+		if r.blocked[rec.Topic] {
+			r.cursor = rec.Id
+			continue
+		}
+
+		// the lease may be lost during a long batch
+		if !r.election.Leader() {
+			r.rewind()
+			return sent
+		}
+
+		entryId, e := r.publish(ctx, rec)
+		if e != nil {
+			r.Errorf("outbox relay publish failed topic: %s, id: %s, err: %s", rec.Topic, rec.MsgId, e)
+			if entryId = r.deadLetter(ctx, rec, e); entryId == "" {
+				r.blocked[rec.Topic] = true
+				r.cursor = rec.Id
+				continue
+			}
+		}
+		delete(r.attempts, rec.Id)
+
+		if e = r.store.MarkSent(ctx, rec.Id, entryId); e != nil {
+			// the record is published again, the dedupe key skips it
+			r.Errorf("outbox relay mark failed row: %d, err: %s", rec.Id, e)
+			r.rewind()
+			return sent
+		}
+
+		sent++
+		r.cursor = rec.Id
+	}
+
+	if len(records) < r.batchSize || len(r.blocked) == 0 {
+		r.rewind()
+	}
+
+	return sent
+}
+
+// rewind reads the next batch from the first unsent record, retrying the blocked topics
+func (r *Relay) rewind() {
+	r.cursor = 0
+	r.blocked = make(map[string]bool)
+}
+
+// deadLetter counts a failed publish of rec, it moves rec to the dead letter queue of its topic after the max attempts
+// and returns the id the record is marked sent with, empty while the record is retried
+func (r *Relay) deadLetter(ctx context.Context, rec *Record, err error) string {
+	r.attempts[rec.Id]++
+	if r.maxAttempts <= 0 || r.attempts[rec.Id] < r.maxAttempts {
+		return ""
+	}
+
+	//a message per tag as published, a broadcast one without tag
+	msgs := []*rsq.Message{{Id: rec.MsgId, Data: rec.Data, Header: rec.Header}}
+	for i, tag := range rec.Tags {
+		if i > 0 {
+			msgs = append(msgs, &rsq.Message{Id: rec.MsgId, Data: rec.Data, Header: rec.Header})
+		}
+		msgs[i].TagId = tag
+	}
+
+	reason := fmt.Sprintf("outbox relay: failed %d publishes: %s", r.attempts[rec.Id], err)
+	id, e := r.admin.AddDeadLetter(ctx, stream.NamespaceKey(r.namespace, rec.Topic), reason, msgs...)
+	if e != nil {
+		r.Errorf("outbox relay dead letter failed topic: %s, id: %s, err: %s", rec.Topic, rec.MsgId, e)
+		return ""
+	}
+
+	r.Warnf("outbox relay dead lettered topic: %s, id: %s after %d attempts", rec.Topic, rec.MsgId, r.attempts[rec.Id])
+
+	return deadLetterPrefix + id
+}
+
+// publish publishes rec once, a record published by a relay which failed to mark it is only marked
+func (r *Relay) publish(ctx context.Context, rec *Record) (string, error) {
+	key := dedupeKey(stream.NamespaceKey(r.namespace, rec.Topic), rec.MsgId)
+
+	entryId, e := r.client.Get(ctx, key).Result()
+	if e == nil {
+		return entryId, nil
+	}
+
+	if e != redis.Nil {
+		return "", e
+	}
+
+	entryId, e = r.producer(rec.Topic).PublishSync(ctx, rec.MsgId, rec.Data, rec.Header, rec.Tags...)
+	if e != nil {
+		return "", e
+	}
+
+	if e = r.client.Set(ctx, key, entryId, r.dedupeTTL).Err(); e != nil {
+		r.Errorf("outbox relay dedupe failed topic: %s, id: %s, err: %s", rec.Topic, rec.MsgId, e)
+	}
+
+	return entryId, nil
+}
+
+func (r *Relay) producer(topic string) rsq.IMQProducer {
+	p, ok := r.producers[topic]
+	if !ok {
+		p = stream.NewProducer(stream.NamespaceKey(r.namespace, topic), r.maxLen, r.client, r.ILogger, r.streamOpts...)
+		p.Start()
+		r.producers[topic] = p
+	}
+
+	return p
+}
+
+// deadLetterPrefix marks the entry ids of the dead lettered records
+const deadLetterPrefix = "dlq:"
+
+func dedupeKey(topic, msgId string) string {
+	return fmt.Sprintf("%s_outbox_%s", topic, msgId)
+}
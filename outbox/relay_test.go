@@ -0,0 +1,146 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/wsk15046/rsq/stream"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+	"time"
+)
+
+func TestRelayBlockedTopic(t *testing.T) {
+	db, e := sql.Open("sqlite3", ":memory:")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	s := NewSQLStore(db, "outbox", SQLite)
+	if e = s.CreateTable(ctx); e != nil {
+		t.Fatal(e)
+	}
+
+	c, l := test.Dependency()
+	for _, key := range []string{"{rsq}:outbox_a", "{rsq}:outbox_b", "rsq_outbox_relay_test"} {
+		c.Del(ctx, key)
+	}
+
+	//the first record of a is too large to publish, it blocks the next one of a but not the ones of b
+	records := []*Record{
+		{Topic: "{rsq}:outbox_a", MsgId: "1", Data: []byte("too large")},
+		{Topic: "{rsq}:outbox_a", MsgId: "2", Data: []byte("a")},
+		{Topic: "{rsq}:outbox_b", MsgId: "3", Data: []byte("b")},
+		{Topic: "{rsq}:outbox_b", MsgId: "4", Data: []byte("b")},
+	}
+	for _, rec := range records {
+		if e = s.Add(ctx, db, rec); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	r := NewRelay(s, "test", c, l, WithBatchSize(2), WithLease("rsq_outbox_relay_test", 10*time.Second),
+		WithMaxAttempts(0), WithStreamOptions(stream.WithMaxMessageSize(4)))
+	defer func() {
+		_ = r.election.Resign()
+		for _, p := range r.producers {
+			p.Stop()
+		}
+	}()
+
+	if err := r.election.Campaign(ctx); err != nil {
+		t.Fatalf("expect the lease, got %v", err)
+	}
+
+	//a first batch with a blocked topic, then the batch past it
+	if sent := r.relay(); sent != 0 {
+		t.Errorf("expect no record of the blocked batch sent, got %d", sent)
+	}
+
+	if sent := r.relay(); sent != 2 {
+		t.Errorf("expect the records of b sent, got %d", sent)
+	}
+
+	if n := c.XLen(ctx, "{rsq}:outbox_a").Val(); n != 0 {
+		t.Errorf("expect nothing published to a, got %d", n)
+	}
+
+	unsent, _ := s.Unsent(ctx, 0, 10)
+	if len(unsent) != 2 || unsent[0].MsgId != "1" || unsent[1].MsgId != "2" {
+		t.Errorf("expect the records of a unsent, got %v", unsent)
+	}
+}
+
+func TestRelayMaxAttempts(t *testing.T) {
+	db, e := sql.Open("sqlite3", ":memory:")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	s := NewSQLStore(db, "outbox", SQLite)
+	if e = s.CreateTable(ctx); e != nil {
+		t.Fatal(e)
+	}
+
+	c, l := test.Dependency()
+	a := stream.NewAdmin(c, l)
+	topic := "{rsq}:outbox_dlq"
+	_ = stream.NewRegistry(c, l).DeleteTopic(ctx, topic)
+	c.Del(ctx, "rsq_outbox_relay_test", dedupeKey(topic, "1"), dedupeKey(topic, "2"))
+
+	//the first record can't ever be published, it is moved aside after 2 attempts
+	for _, rec := range []*Record{
+		{Topic: topic, MsgId: "1", Data: []byte("too large"), Tags: []string{"a", "b"}},
+		{Topic: topic, MsgId: "2", Data: []byte("a")},
+	} {
+		if e = s.Add(ctx, db, rec); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	r := NewRelay(s, "test", c, l, WithLease("rsq_outbox_relay_test", 10*time.Second),
+		WithMaxAttempts(2), WithStreamOptions(stream.WithMaxMessageSize(4)))
+	defer func() {
+		_ = r.election.Resign()
+		for _, p := range r.producers {
+			p.Stop()
+		}
+	}()
+
+	if err := r.election.Campaign(ctx); err != nil {
+		t.Fatalf("expect the lease, got %v", err)
+	}
+
+	if sent := r.relay(); sent != 0 {
+		t.Errorf("expect the topic blocked by the first attempt, got %d sent", sent)
+	}
+
+	if sent := r.relay(); sent != 2 {
+		t.Errorf("expect the record dead lettered and the next one sent, got %d sent", sent)
+	}
+
+	if unsent, _ := s.Unsent(ctx, 0, 10); len(unsent) != 0 {
+		t.Errorf("expect no unsent record, got %v", unsent)
+	}
+
+	letters, err := a.DeadLetters(ctx, topic, "", 10)
+	if err != nil || len(letters) != 1 || len(letters[0].Messages) != 2 {
+		t.Fatalf("expect the record dead lettered with a message per tag, got %v %v", letters, err)
+	}
+
+	var entryId string
+	_ = db.QueryRow("SELECT entry_id FROM outbox WHERE msg_id = '1'").Scan(&entryId)
+	if entryId != deadLetterPrefix+letters[0].Id {
+		t.Errorf("expect the record marked with its dead letter, got %s", entryId)
+	}
+
+	if n := c.XLen(ctx, topic).Val(); n != 1 {
+		t.Errorf("expect the next record published, got %d", n)
+	}
+}
@@ -0,0 +1,187 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dialect holds the sql differing between databases
+type Dialect struct {
+	Placeholder func(i int) string // placeholder of the i-th parameter from 1
+	AutoId      string             // column type of the auto increment id
+	Blob        string             // column type of the data
+}
+
+var (
+	SQLite = &Dialect{
+		Placeholder: func(i int) string { return "?" },
+		AutoId:      "INTEGER PRIMARY KEY AUTOINCREMENT",
+		Blob:        "BLOB",
+	}
+
+	MySQL = &Dialect{
+		Placeholder: func(i int) string { return "?" },
+		AutoId:      "BIGINT AUTO_INCREMENT PRIMARY KEY",
+		Blob:        "LONGBLOB",
+	}
+
+	Postgres = &Dialect{
+		Placeholder: func(i int) string { return fmt.Sprintf("$%d", i) },
+		AutoId:      "BIGSERIAL PRIMARY KEY",
+		Blob:        "BYTEA",
+	}
+)
+
+// Execer is a *sql.Tx or a *sql.DB
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLStore keeps the outbox in a table of a sql database
+type SQLStore struct {
+	db      *sql.DB
+	table   string
+	dialect *Dialect
+}
+
+func NewSQLStore(db *sql.DB, table string, dialect *Dialect) *SQLStore {
+	return &SQLStore{
+		db:      db,
+		table:   table,
+		dialect: dialect,
+	}
+}
+
+// query replaces the ? of q by the placeholders of the dialect
+func (s *SQLStore) query(q string) string {
+	var b strings.Builder
+
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString(s.dialect.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.ReplaceAll(b.String(), "{table}", s.table)
+}
+
+// CreateTable creates the outbox table, the unique index on topic and msg_id makes adding a message twice fail
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	stmts := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	topic VARCHAR(255) NOT NULL,
+	msg_id VARCHAR(255) NOT NULL,
+	data %s,
+	header TEXT,
+	tags TEXT,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT,
+	entry_id VARCHAR(64)
+)`, s.table, s.dialect.AutoId, s.dialect.Blob),
+		fmt.Sprintf("CREATE UNIQUE INDEX %s_msg ON %s (topic, msg_id)", s.table, s.table),
+		fmt.Sprintf("CREATE INDEX %s_unsent ON %s (sent_at, id)", s.table, s.table),
+	}
+
+	for i, stmt := range stmts {
+		if _, e := s.db.ExecContext(ctx, stmt); e != nil {
+			// the indexes exist with the table
+			msg := strings.ToLower(e.Error())
+			if i > 0 && (strings.Contains(msg, "exist") || strings.Contains(msg, "duplicate")) {
+				continue
+			}
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Add inserts r with ex, the transaction of the business writes
+func (s *SQLStore) Add(ctx context.Context, ex Execer, r *Record) error {
+	if r.Topic == "" || r.MsgId == "" || strings.Contains(r.MsgId, "-") {
+		return errors.New("topic and msg id are required, the msg id can't contain '-'")
+	}
+
+	header, e := json.Marshal(r.Header)
+	if e != nil {
+		return e
+	}
+
+	tags, e := json.Marshal(r.Tags)
+	if e != nil {
+		return e
+	}
+
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+
+	_, e = ex.ExecContext(ctx, s.query("INSERT INTO {table} (topic, msg_id, data, header, tags, created_at) VALUES (?, ?, ?, ?, ?, ?)"),
+		r.Topic, r.MsgId, r.Data, string(header), string(tags), r.CreatedAt.UnixMilli())
+
+	return e
+}
+
+func (s *SQLStore) Unsent(ctx context.Context, after int64, limit int) ([]*Record, error) {
+	rows, e := s.db.QueryContext(ctx,
+		s.query("SELECT id, topic, msg_id, data, header, tags, created_at FROM {table} WHERE sent_at IS NULL AND id > ? ORDER BY id LIMIT ?"), after, limit)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	var records []*Record
+	for rows.Next() {
+		var r Record
+		var header, tags sql.NullString
+		var createdAt int64
+
+		if e = rows.Scan(&r.Id, &r.Topic, &r.MsgId, &r.Data, &header, &tags, &createdAt); e != nil {
+			return nil, e
+		}
+
+		if header.Valid {
+			if e = json.Unmarshal([]byte(header.String), &r.Header); e != nil {
+				return nil, fmt.Errorf("invalid header of outbox row %d: %w", r.Id, e)
+			}
+		}
+
+		if tags.Valid {
+			if e = json.Unmarshal([]byte(tags.String), &r.Tags); e != nil {
+				return nil, fmt.Errorf("invalid tags of outbox row %d: %w", r.Id, e)
+			}
+		}
+
+		r.CreatedAt = time.UnixMilli(createdAt)
+		records = append(records, &r)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *SQLStore) MarkSent(ctx context.Context, id int64, entryId string) error {
+	_, e := s.db.ExecContext(ctx, s.query("UPDATE {table} SET sent_at = ?, entry_id = ? WHERE id = ?"),
+		time.Now().UnixMilli(), entryId, id)
+
+	return e
+}
+
+// DeleteSent deletes the rows sent before the given time and returns their number
+func (s *SQLStore) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	res, e := s.db.ExecContext(ctx, s.query("DELETE FROM {table} WHERE sent_at IS NOT NULL AND sent_at < ?"), before.UnixMilli())
+	if e != nil {
+		return 0, e
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+func TestSQLStore(t *testing.T) {
+	db, e := sql.Open("sqlite3", ":memory:")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.Close()
+
+	// a single connection keeps the memory database
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	s := NewSQLStore(db, "outbox", SQLite)
+
+	for i := 0; i < 2; i++ {
+		if e = s.CreateTable(ctx); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	tx, _ := db.BeginTx(ctx, nil)
+	for _, id := range []string{"1", "2", "3"} {
+		if e = s.Add(ctx, tx, &Record{Topic: "orders", MsgId: id, Data: []byte(id), Header: map[string]string{"k": id}, Tags: []string{"a"}}); e != nil {
+			t.Fatal(e)
+		}
+	}
+	_ = tx.Commit()
+
+	if e = s.Add(ctx, db, &Record{Topic: "orders", MsgId: "2"}); e == nil {
+		t.Errorf("expect a duplicate msg id to fail")
+	}
+
+	tx, _ = db.BeginTx(ctx, nil)
+	_ = s.Add(ctx, tx, &Record{Topic: "orders", MsgId: "4"})
+	_ = tx.Rollback()
+
+	records, e := s.Unsent(ctx, 0, 10)
+	if e != nil || len(records) != 3 {
+		t.Fatalf("expect 3 unsent records, got %d %v", len(records), e)
+	}
+
+	for i, r := range records {
+		if i > 0 && r.Id <= records[i-1].Id {
+			t.Errorf("records out of order %d %d", records[i-1].Id, r.Id)
+		}
+
+		if r.Header["k"] != r.MsgId || string(r.Data) != r.MsgId || len(r.Tags) != 1 {
+			t.Errorf("unexpected record %+v", r)
+		}
+	}
+
+	if e = s.MarkSent(ctx, records[0].Id, "1-0"); e != nil {
+		t.Fatal(e)
+	}
+
+	if records, _ = s.Unsent(ctx, 0, 10); len(records) != 2 || records[0].MsgId != "2" {
+		t.Errorf("expect 2 unsent records from 2, got %v", records)
+	}
+
+	if records, _ = s.Unsent(ctx, records[0].Id, 10); len(records) != 1 || records[0].MsgId != "3" {
+		t.Errorf("expect the unsent record after 2, got %v", records)
+	}
+}
@@ -0,0 +1,35 @@
+// Package outbox publishes messages written in the same database transaction as the business data.
+//
+// The messages are added to an outbox table by the transaction, a Relay reads the unsent rows
+// in their order, publishes them with the synchronous path of the producer and marks them sent.
+// Only one relay is active at a time, the others wait for its lease to expire.
+//
+//	tx, _ := db.BeginTx(ctx, nil)
+//	// business writes
+//	_ = store.Add(ctx, tx, &outbox.Record{Topic: "orders", MsgId: "42", Data: data})
+//	_ = tx.Commit()
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a message of the outbox
+type Record struct {
+	Id        int64 // set by the store, the rows are published in its order
+	Topic     string
+	MsgId     string // unique within the topic, it is the id of the published message
+	Data      []byte
+	Header    map[string]string
+	Tags      []string // broadcast when empty
+	CreatedAt time.Time
+}
+
+// Store is read by the relay
+type Store interface {
+	// Unsent returns up to limit unsent records with an id above after, ordered by their id
+	Unsent(ctx context.Context, after int64, limit int) ([]*Record, error)
+	// MarkSent records the entry id of a published record
+	MarkSent(ctx context.Context, id int64, entryId string) error
+}
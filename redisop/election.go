@@ -0,0 +1,180 @@
+package redisop
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Election elects one leader among the processes campaigning on the same key.
+// The leader holds a RedisLock renewed in the background, it is revoked when the lock is lost.
+type Election struct {
+	rsq.ILogger
+
+	lock   *RedisLock
+	leader atomic.Bool
+	ch     chan bool
+
+	onElected func()
+	onRevoked func()
+
+	lost  <-chan struct{}
+	mutex sync.Mutex
+}
+
+// NewElection creates an election on key, a follower campaigns again every third of the ttl, see SetRetry
+func NewElection(c redis.UniversalClient, l rsq.ILogger, key string, ttl time.Duration) *Election {
+	lock := NewRedisLock(c, l, key, ttl)
+	lock.SetRetry(ttl / 3)
+
+	return &Election{
+		ILogger: l,
+		lock:    lock,
+		ch:      make(chan bool, 1),
+	}
+}
+
+// SetRetry sets the interval between the campaign attempts, the shorter it is the sooner a lost leader is replaced
+// and the more often the followers poll the key
+func (e *Election) SetRetry(retry time.Duration) {
+	e.lock.SetRetry(retry)
+}
+
+// OnElected sets the callback run when the process becomes the leader
+func (e *Election) OnElected(f func()) {
+	e.onElected = f
+}
+
+// OnRevoked sets the callback run when the process stops being the leader
+func (e *Election) OnRevoked(f func()) {
+	e.onRevoked = f
+}
+
+// Leader tells whether the process is the leader
+func (e *Election) Leader() bool {
+	return e.leader.Load()
+}
+
+// IsLeader returns a channel receiving the leadership changes, only the last one is kept for a slow reader
+func (e *Election) IsLeader() <-chan bool {
+	return e.ch
+}
+
+// Campaign waits until the process is elected or ctx is done
+func (e *Election) Campaign(ctx context.Context) (err *kerror.KError) {
+	if err = e.lock.Lock(ctx); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	if e.leader.Load() {
+		e.mutex.Unlock()
+		return nil
+	}
+
+	lost := e.lock.AutoRenew()
+	e.lost = lost
+	e.setLeader(true)
+	e.mutex.Unlock()
+
+	e.callback(true)
+
+	go func() {
+		<-lost
+
+		e.mutex.Lock()
+		// resigned or elected again meanwhile
+		revoked := e.lost == lost
+		if revoked {
+			e.lost = nil
+			e.setLeader(false)
+		}
+		e.mutex.Unlock()
+
+		if revoked {
+			e.callback(false)
+		}
+	}()
+
+	return nil
+}
+
+// Resign gives up the leadership
+func (e *Election) Resign() (err *kerror.KError) {
+	e.mutex.Lock()
+	if !e.leader.Load() {
+		e.mutex.Unlock()
+		return nil
+	}
+
+	e.lost = nil
+	e.setLeader(false)
+	e.mutex.Unlock()
+
+	e.callback(false)
+
+	if err = e.lock.Unlock(); err != nil && err.Code() != kerror.LockLostError.Code() {
+		return err
+	}
+
+	return nil
+}
+
+// Run campaigns again each time the leadership is lost, until ctx is done and the process resigns
+func (e *Election) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := e.Campaign(ctx); err != nil {
+			if ctx.Err() == nil {
+				e.Errorf("campaign failed, key[ %s ] err[ %s ]", e.lock.Key(), err.Error())
+				time.Sleep(e.lock.retry)
+			}
+			continue
+		}
+
+		e.mutex.Lock()
+		lost := e.lost
+		e.mutex.Unlock()
+
+		if lost == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-lost:
+		}
+	}
+
+	if err := e.Resign(); err != nil {
+		e.Errorf("resign failed, key[ %s ] err[ %s ]", e.lock.Key(), err.Error())
+	}
+}
+
+// setLeader notifies the change, the caller holds the mutex
+func (e *Election) setLeader(leader bool) {
+	e.leader.Store(leader)
+
+	select {
+	case <-e.ch:
+	default:
+	}
+	e.ch <- leader
+}
+
+func (e *Election) callback(leader bool) {
+	if leader {
+		e.Infof("elected leader, key[ %s ]", e.lock.Key())
+		if e.onElected != nil {
+			e.onElected()
+		}
+	} else {
+		e.Infof("leadership revoked, key[ %s ]", e.lock.Key())
+		if e.onRevoked != nil {
+			e.onRevoked()
+		}
+	}
+}
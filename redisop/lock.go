@@ -0,0 +1,240 @@
+package redisop
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/util"
+	"sync"
+	"time"
+)
+
+// the lock key holds the token of its owner, only the owner releases or extends it
+const UnlockScript = `
+	if redis.call("GET",KEYS[1]) == ARGV[1] then
+		return redis.call("DEL",KEYS[1])
+	end
+	return 0
+`
+
+const ExtendScript = `
+	if redis.call("GET",KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE",KEYS[1],ARGV[2])
+	end
+	return 0
+`
+
+var (
+	unlockScript = redis.NewScript(UnlockScript)
+	extendScript = redis.NewScript(ExtendScript)
+)
+
+// RedisLock is a lock shared by processes, it expires after its ttl unless it is extended
+type RedisLock struct {
+	cl redis.UniversalClient
+	rsq.ILogger
+
+	key   string
+	ttl   time.Duration
+	retry time.Duration
+
+	token string
+	stop  chan struct{}
+	mutex sync.Mutex
+}
+
+func NewRedisLock(c redis.UniversalClient, l rsq.ILogger, key string, ttl time.Duration) *RedisLock {
+	return &RedisLock{cl: c, ILogger: l, key: key, ttl: ttl, retry: 100 * time.Millisecond}
+}
+
+// SetRetry sets the interval between the attempts of Lock, 100ms by default
+func (c *RedisLock) SetRetry(retry time.Duration) {
+	if retry > 0 {
+		c.retry = retry
+	}
+}
+
+func (c *RedisLock) Key() string {
+	return c.key
+}
+
+// TryLock takes the lock if it is free, it returns false when another owner holds it.
+// A lock already held is checked in redis, it is taken again when it expired.
+func (c *RedisLock) TryLock() (ok bool, err *kerror.KError) {
+	ctx := context.Background()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != "" {
+		owner, e := c.cl.Get(ctx, c.key).Result()
+		if e != nil && e != redis.Nil {
+			c.Errorf("lock failed, key[ %s ] err[ %s ]", c.key, e.Error())
+			return false, kerror.RedisError.Msg(e.Error())
+		}
+
+		if owner == c.token {
+			return true, nil
+		}
+
+		c.token = ""
+		c.stopRenew()
+	}
+
+	token := util.RandString(20)
+
+	ok, e := c.cl.SetNX(ctx, c.key, token, c.ttl).Result()
+	if e != nil {
+		c.Errorf("lock failed, key[ %s ] err[ %s ]", c.key, e.Error())
+		return false, kerror.RedisError.Msg(e.Error())
+	}
+
+	if ok {
+		c.token = token
+	}
+
+	return ok, nil
+}
+
+// Lock waits for the lock until ctx is done
+func (c *RedisLock) Lock(ctx context.Context) (err *kerror.KError) {
+	for {
+		ok, e := c.TryLock()
+		if e != nil {
+			return e
+		}
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return kerror.LockedError.Msgf("lock %s: %s", c.key, ctx.Err())
+		case <-time.After(c.retry):
+		}
+	}
+}
+
+// Unlock releases the lock, it fails with LockLostError when the lock expired or has another owner
+func (c *RedisLock) Unlock() (err *kerror.KError) {
+	ctx := context.Background()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	token := c.token
+	c.token = ""
+	c.stopRenew()
+
+	if token == "" {
+		return kerror.LockLostError.Msgf("lock %s", c.key)
+	}
+
+	n, e := unlockScript.Run(ctx, c.cl, []string{c.key}, token).Int64()
+	if e != nil {
+		c.Errorf("unlock failed, key[ %s ] err[ %s ]", c.key, e.Error())
+		return kerror.RedisError.Msg(e.Error())
+	}
+
+	if n == 0 {
+		return kerror.LockLostError.Msgf("lock %s", c.key)
+	}
+
+	return nil
+}
+
+// Extend resets the ttl of the lock, it fails with LockLostError when the lock is no longer held
+func (c *RedisLock) Extend(ttl time.Duration) (err *kerror.KError) {
+	ctx := context.Background()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token == "" {
+		return kerror.LockLostError.Msgf("lock %s", c.key)
+	}
+
+	n, e := extendScript.Run(ctx, c.cl, []string{c.key}, c.token, ttl.Milliseconds()).Int64()
+	if e != nil {
+		c.Errorf("extend lock failed, key[ %s ] err[ %s ]", c.key, e.Error())
+		return kerror.RedisError.Msg(e.Error())
+	}
+
+	if n == 0 {
+		c.token = ""
+		c.stopRenew()
+		return kerror.LockLostError.Msgf("lock %s", c.key)
+	}
+
+	return nil
+}
+
+// AutoRenew extends the held lock every third of its ttl until Unlock,
+// the returned channel is closed when the lock is lost or released.
+func (c *RedisLock) AutoRenew() <-chan struct{} {
+	lost := make(chan struct{})
+
+	c.mutex.Lock()
+	if c.token == "" {
+		c.mutex.Unlock()
+		close(lost)
+		return lost
+	}
+
+	c.stopRenew()
+	stop := make(chan struct{})
+	c.stop = stop
+	c.mutex.Unlock()
+
+	go func() {
+		defer close(lost)
+
+		ticker := time.NewTicker(c.ttl / 3)
+		defer ticker.Stop()
+
+		extended := time.Now()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := c.Extend(c.ttl)
+				if err == nil {
+					extended = time.Now()
+					continue
+				}
+
+				// a redis error is retried until the ttl runs out
+				if err.Code() == kerror.LockLostError.Code() || time.Since(extended) > c.ttl {
+					c.Warnf("lock lost, key[ %s ] err[ %s ]", c.key, err.Error())
+					c.giveUp(stop)
+					return
+				}
+			}
+		}
+	}()
+
+	return lost
+}
+
+// stopRenew stops the watchdog, the caller holds the mutex
+func (c *RedisLock) stopRenew() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+// giveUp drops the token of a lock the watchdog could not extend in its ttl, it may be held by another owner by now
+func (c *RedisLock) giveUp(stop chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stop == stop {
+		c.token = ""
+		c.stopRenew()
+	}
+}
@@ -0,0 +1,177 @@
+package redisop
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/util"
+	"time"
+)
+
+// the scripts read the clock of redis so that all the processes share it, they return {allowed, wait ms}
+
+const TokenBucketScript = `
+	redis.replicate_commands()
+
+	local rate = tonumber(ARGV[1])
+	local burst = tonumber(ARGV[2])
+	local n = tonumber(ARGV[3])
+
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+	local v = redis.call("HMGET",KEYS[1],"tokens","ts")
+	local tokens = tonumber(v[1]) or burst
+	local ts = tonumber(v[2]) or now
+
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+
+	local allowed = 0
+	local wait = 0
+
+	if tokens >= n then
+		tokens = tokens - n
+		allowed = 1
+	else
+		wait = math.ceil((n - tokens) * 1000 / rate)
+	end
+
+	redis.call("HSET",KEYS[1],"tokens",tostring(tokens),"ts",now)
+	redis.call("PEXPIRE",KEYS[1],math.ceil(burst * 1000 / rate) + 1000)
+
+	return {allowed, wait}
+`
+
+const SlidingWindowScript = `
+	redis.replicate_commands()
+
+	local window = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	local n = tonumber(ARGV[3])
+
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+	redis.call("ZREMRANGEBYSCORE",KEYS[1],"-inf",now - window)
+
+	local count = redis.call("ZCARD",KEYS[1])
+	if count + n <= limit then
+		for i = 1, n do
+			redis.call("ZADD",KEYS[1],now,ARGV[4] .. ":" .. i)
+		end
+		redis.call("PEXPIRE",KEYS[1],window)
+		return {1, 0}
+	end
+
+	local wait = window
+	local oldest = redis.call("ZRANGE",KEYS[1],0,0,"WITHSCORES")
+	if oldest[2] then
+		wait = tonumber(oldest[2]) + window - now
+	end
+
+	return {0, wait}
+`
+
+var (
+	tokenBucketScript   = redis.NewScript(TokenBucketScript)
+	slidingWindowScript = redis.NewScript(SlidingWindowScript)
+)
+
+// RateLimiter limits the events of a key across processes
+type RateLimiter interface {
+	// Allow takes n events if the limit allows them, wait is the delay before retrying otherwise
+	Allow(key string, n int64) (ok bool, wait time.Duration, err *kerror.KError)
+	// Wait blocks until n events are allowed or ctx is done
+	Wait(ctx context.Context, key string, n int64) (err *kerror.KError)
+}
+
+// TokenBucket allows rate events per second on average and bursts of up to burst events
+type TokenBucket struct {
+	cl redis.UniversalClient
+	rsq.ILogger
+
+	rate  float64
+	burst int64
+}
+
+func NewTokenBucket(c redis.UniversalClient, l rsq.ILogger, rate float64, burst int64) *TokenBucket {
+	return &TokenBucket{cl: c, ILogger: l, rate: rate, burst: burst}
+}
+
+func (c *TokenBucket) Allow(key string, n int64) (ok bool, wait time.Duration, err *kerror.KError) {
+	if n > c.burst {
+		return false, 0, kerror.SystemError.Msgf("%d events exceed the burst %d", n, c.burst)
+	}
+
+	return runLimiter(c.ILogger, tokenBucketScript, c.cl, key, c.rate, c.burst, n)
+}
+
+func (c *TokenBucket) Wait(ctx context.Context, key string, n int64) (err *kerror.KError) {
+	return waitLimiter(ctx, c, key, n)
+}
+
+// SlidingWindow allows limit events in any window of time
+type SlidingWindow struct {
+	cl redis.UniversalClient
+	rsq.ILogger
+
+	window time.Duration
+	limit  int64
+}
+
+func NewSlidingWindow(c redis.UniversalClient, l rsq.ILogger, window time.Duration, limit int64) *SlidingWindow {
+	return &SlidingWindow{cl: c, ILogger: l, window: window, limit: limit}
+}
+
+func (c *SlidingWindow) Allow(key string, n int64) (ok bool, wait time.Duration, err *kerror.KError) {
+	if n > c.limit {
+		return false, 0, kerror.SystemError.Msgf("%d events exceed the limit %d", n, c.limit)
+	}
+
+	// the members of the events must be unique across processes
+	return runLimiter(c.ILogger, slidingWindowScript, c.cl, key, c.window.Milliseconds(), c.limit, n, util.RandString(16))
+}
+
+func (c *SlidingWindow) Wait(ctx context.Context, key string, n int64) (err *kerror.KError) {
+	return waitLimiter(ctx, c, key, n)
+}
+
+func runLimiter(l rsq.ILogger, script *redis.Script, cl redis.UniversalClient, key string, args ...interface{}) (bool, time.Duration, *kerror.KError) {
+	ctx := context.Background()
+
+	ret, e := script.Run(ctx, cl, []string{key}, args...).Int64Slice()
+	if e != nil {
+		l.Errorf("rate limit failed, key[ %s ] err[ %s ]", key, e.Error())
+		return false, 0, kerror.RedisError.Msg(e.Error())
+	}
+
+	if len(ret) != 2 {
+		return false, 0, kerror.RedisError.Msgf("unexpected rate limit reply %v", ret)
+	}
+
+	return ret[0] == 1, time.Duration(ret[1]) * time.Millisecond, nil
+}
+
+func waitLimiter(ctx context.Context, rl RateLimiter, key string, n int64) *kerror.KError {
+	for {
+		ok, wait, err := rl.Allow(key, n)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		if wait <= 0 {
+			wait = time.Millisecond
+		}
+
+		select {
+		case <-ctx.Done():
+			return kerror.SystemError.Msgf("rate limit wait of %s: %s", key, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
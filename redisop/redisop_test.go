@@ -1,7 +1,9 @@
 package redisop
 
 import (
+	"context"
 	"fmt"
+	"github.com/wsk15046/rsq/kerror"
 	"github.com/wsk15046/rsq/test"
 	"github.com/wsk15046/rsq/util"
 	"math/rand"
@@ -222,3 +224,182 @@ func TestHashStruct(t *testing.T) {
 
 	group.Wait()
 }
+
+func TestRedisLock(t *testing.T) {
+	c, l := test.Dependency()
+
+	key := fmt.Sprintf("base:locktest:%s", util.RandString(10))
+
+	a := NewRedisLock(c, l, key, time.Second)
+	b := NewRedisLock(c, l, key, time.Second)
+
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("lock failed %v", err)
+	}
+
+	if ok, err := b.TryLock(); err != nil || ok {
+		t.Fatalf("lock held twice %v", err)
+	}
+
+	lost := a.AutoRenew()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the watchdog keeps the lock past its ttl
+	if err := b.Lock(ctx); err == nil || err.Code() != kerror.LockedError.Code() {
+		t.Errorf("expect a locked error, got %v", err)
+	}
+
+	if err := b.Unlock(); err == nil || err.Code() != kerror.LockLostError.Code() {
+		t.Errorf("expect a lost error, got %v", err)
+	}
+
+	if err := a.Unlock(); err != nil {
+		t.Error(err)
+	}
+
+	<-lost
+
+	if err := b.Lock(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if err := b.Extend(2 * time.Second); err != nil {
+		t.Error(err)
+	}
+
+	if err := b.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisLockExpired(t *testing.T) {
+	c, l := test.Dependency()
+
+	key := fmt.Sprintf("base:locktest:%s", util.RandString(10))
+
+	a := NewRedisLock(c, l, key, time.Second)
+	b := NewRedisLock(c, l, key, time.Second)
+
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("lock failed %v", err)
+	}
+
+	// not renewed, the lock expires and is taken by b
+	c.Del(context.Background(), key)
+
+	if ok, err := b.TryLock(); err != nil || !ok {
+		t.Fatalf("expired lock not taken %v", err)
+	}
+
+	if ok, err := a.TryLock(); err != nil || ok {
+		t.Fatalf("lock held twice %v", err)
+	}
+
+	if err := b.Unlock(); err != nil {
+		t.Error(err)
+	}
+
+	// a takes it again once it is free
+	if ok, err := a.TryLock(); err != nil || !ok {
+		t.Fatalf("lock failed %v", err)
+	}
+
+	if err := a.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestElection(t *testing.T) {
+	c, l := test.Dependency()
+
+	key := fmt.Sprintf("base:electiontest:%s", util.RandString(10))
+
+	a := NewElection(c, l, key, time.Second)
+	b := NewElection(c, l, key, time.Second)
+
+	revoked := make(chan bool, 1)
+	a.OnRevoked(func() { revoked <- true })
+
+	if err := a.Campaign(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !<-a.IsLeader() || !a.Leader() {
+		t.Error("a is not the leader")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := b.Campaign(ctx); err == nil {
+		t.Error("b elected with a leader")
+	}
+
+	go b.Run(context.Background())
+
+	if err := a.Resign(); err != nil {
+		t.Error(err)
+	}
+
+	<-revoked
+
+	select {
+	case leader := <-b.IsLeader():
+		if !leader {
+			t.Error("b is not the leader")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("b not elected after a resigned")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	c, l := test.Dependency()
+
+	limiters := map[string]RateLimiter{
+		"bucket": NewTokenBucket(c, l, 10, 5),
+		"window": NewSlidingWindow(c, l, time.Second, 5),
+	}
+
+	for name, rl := range limiters {
+		key := fmt.Sprintf("base:ratetest:%s:%s", name, util.RandString(10))
+
+		for i := 0; i < 5; i++ {
+			if ok, _, err := rl.Allow(key, 1); err != nil || !ok {
+				t.Fatalf("%s event %d refused %v", name, i, err)
+			}
+		}
+
+		ok, wait, err := rl.Allow(key, 1)
+		if err != nil || ok || wait <= 0 || wait > time.Second {
+			t.Errorf("%s expect a refusal with a wait, got %v %s %v", name, ok, wait, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err = rl.Wait(ctx, key, 1); err != nil {
+			t.Errorf("%s wait failed %v", name, err)
+		}
+		cancel()
+	}
+}
+
+func TestElectionRetry(t *testing.T) {
+	c, l := test.Dependency()
+
+	e := NewElection(c, l, "base:electiontest:retry", 3*time.Second)
+	if e.lock.retry != time.Second {
+		t.Errorf("expect a third of the ttl, got %s", e.lock.retry)
+	}
+
+	e.SetRetry(200 * time.Millisecond)
+	if e.lock.retry != 200*time.Millisecond {
+		t.Errorf("expect the retry set, got %s", e.lock.retry)
+	}
+
+	e.SetRetry(0)
+	if e.lock.retry != 200*time.Millisecond {
+		t.Errorf("expect the retry kept, got %s", e.lock.retry)
+	}
+}
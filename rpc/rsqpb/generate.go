@@ -0,0 +1,4 @@
+// Package rsqpb holds the protobuf messages and the grpc service generated from rsq.proto.
+package rsqpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rsq.proto
@@ -0,0 +1,344 @@
+// Package rpc serves rsq topics over grpc, see rsqpb/rsq.proto for the service definition.
+//
+//	srv := grpc.NewServer()
+//	rsqpb.RegisterQueueServer(srv, rpc.NewServer(client, logger, rpc.WithAuth(rpc.AllowTopics("orders.*"))))
+//
+// Subscribe streams the messages of a group to the client as long as the call lasts, keeping at most
+// max inflight of them unacked. Ack settles them, Nack hands them back to the group or dead letters them,
+// and those settled by neither within the ack timeout go to the next Subscribe of the group.
+// The Authorizer gets the context of each call, with the grpc metadata of the client, and the rsq errors
+// are returned as grpc status codes.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/internal/edge"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/rpc/rsqpb"
+	"github.com/wsk15046/rsq/stream"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	ActionPublish = edge.ActionPublish
+	ActionConsume = edge.ActionConsume
+)
+
+// Authorizer allows an action on a topic, requests are refused when no Authorizer is set
+type Authorizer func(ctx context.Context, action, topic string) error
+
+// AllowTopics allows every action on the topics matching one of patterns, see path.Match
+func AllowTopics(patterns ...string) Authorizer {
+	return func(ctx context.Context, action, topic string) error {
+		if e := edge.MatchTopic(patterns, topic); e != nil {
+			return status.Error(codes.PermissionDenied, e.Error())
+		}
+
+		return nil
+	}
+}
+
+type Option func(s *Server)
+
+// WithAuth gates the requests behind auth
+func WithAuth(auth Authorizer) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// WithMaxGroups sets the max group consumers kept by the server, and how long an unused one is kept
+func WithMaxGroups(n int, idle time.Duration) Option {
+	return func(s *Server) {
+		s.cfg.MaxGroups = n
+		s.cfg.GroupIdle = idle
+	}
+}
+
+// WithMaxLen sets the max length of the topics written by the server
+func WithMaxLen(n int64) Option {
+	return func(s *Server) {
+		s.cfg.MaxLen = n
+	}
+}
+
+// WithAckTimeout sets the default delay before an unacked entry is delivered again
+func WithAckTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.cfg.AckTimeout = d
+	}
+}
+
+// WithMaxInflight sets the default max unacked entries of a subscriber
+func WithMaxInflight(n int64) Option {
+	return func(s *Server) {
+		s.cfg.MaxInflight = n
+	}
+}
+
+// WithMaxBatch sets the max messages of a PublishBatch
+func WithMaxBatch(n int) Option {
+	return func(s *Server) {
+		s.maxBatch = n
+	}
+}
+
+// WithStreamOptions sets the options of the producers and groups created by the server
+func WithStreamOptions(opts ...stream.Option) Option {
+	return func(s *Server) {
+		s.cfg.StreamOpts = opts
+	}
+}
+
+type Server struct {
+	rsqpb.UnimplementedQueueServer
+	rsq.ILogger
+
+	cfg      edge.Config
+	maxBatch int
+	auth     Authorizer
+	topics   *edge.Topics
+}
+
+func NewServer(cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Server {
+	s := &Server{
+		ILogger: l,
+
+		cfg:      edge.DefaultConfig(),
+		maxBatch: 1000,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.topics = edge.NewTopics(cl, l, s.cfg)
+
+	return s
+}
+
+// group takes the group of a request from the pool, it is given back with s.release
+func (s *Server) group(ctx context.Context, topic, group, consumer string) (*stream.Group, error) {
+	if topic == "" || group == "" || consumer == "" {
+		return nil, status.Error(codes.InvalidArgument, "topic, group and consumer are required")
+	}
+
+	if e := s.authorize(ctx, ActionConsume, topic); e != nil {
+		return nil, e
+	}
+
+	g, e := s.topics.Groups().Acquire(topic, group, consumer)
+	if e != nil {
+		return nil, status.Error(codes.ResourceExhausted, e.Error())
+	}
+
+	return g, nil
+}
+
+func (s *Server) release(g *stream.Group) {
+	s.topics.Groups().Release(g)
+}
+
+func (s *Server) authorize(ctx context.Context, action, topic string) error {
+	if s.auth == nil {
+		return status.Error(codes.PermissionDenied, "no authorizer")
+	}
+
+	if e := s.auth(ctx, action, topic); e != nil {
+		if _, ok := status.FromError(e); ok {
+			return e
+		}
+		return status.Error(codes.PermissionDenied, e.Error())
+	}
+
+	return nil
+}
+
+// Close stops the producers and the groups started by the server
+func (s *Server) Close() {
+	s.topics.Close()
+}
+
+func (s *Server) Publish(ctx context.Context, req *rsqpb.PublishRequest) (*rsqpb.PublishResponse, error) {
+	entryId, e := s.publish(ctx, req)
+	if e != nil {
+		return nil, e
+	}
+
+	return &rsqpb.PublishResponse{EntryId: entryId}, nil
+}
+
+func (s *Server) PublishBatch(ctx context.Context, req *rsqpb.PublishBatchRequest) (*rsqpb.PublishBatchResponse, error) {
+	if len(req.Messages) > s.maxBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "batch of %d messages exceeds %d", len(req.Messages), s.maxBatch)
+	}
+
+	resp := &rsqpb.PublishBatchResponse{Results: make([]*rsqpb.PublishResult, 0, len(req.Messages))}
+
+	for _, m := range req.Messages {
+		// a missed deadline fails the remaining messages with it
+		entryId, e := s.publish(ctx, m)
+		if e != nil {
+			st := status.Convert(e)
+			resp.Results = append(resp.Results, &rsqpb.PublishResult{Code: int32(st.Code()), Error: st.Message()})
+			continue
+		}
+
+		resp.Results = append(resp.Results, &rsqpb.PublishResult{EntryId: entryId})
+	}
+
+	return resp, nil
+}
+
+func (s *Server) publish(ctx context.Context, req *rsqpb.PublishRequest) (string, error) {
+	if req.Topic == "" {
+		return "", status.Error(codes.InvalidArgument, "topic is required")
+	}
+
+	if e := edge.ValidateMessage(req.Id, req.Tags); e != nil {
+		return "", status.Error(codes.InvalidArgument, e.Error())
+	}
+
+	if e := s.authorize(ctx, ActionPublish, req.Topic); e != nil {
+		return "", e
+	}
+
+	entryId, e := s.topics.Producer(req.Topic).PublishSync(ctx, req.Id, req.Data, req.Headers, req.Tags...)
+	if e != nil {
+		return "", toStatus(ctx, e)
+	}
+
+	return entryId, nil
+}
+
+func (s *Server) Subscribe(req *rsqpb.SubscribeRequest, ss rsqpb.Queue_SubscribeServer) error {
+	g, e := s.group(ss.Context(), req.Topic, req.Group, req.Consumer)
+	if e != nil {
+		return e
+	}
+	defer s.release(g)
+
+	maxInflight := s.cfg.MaxInflight
+	if req.MaxInflight > 0 {
+		maxInflight = req.MaxInflight
+	}
+
+	ackTimeout := s.cfg.AckTimeout
+	if req.AckTimeoutMs > 0 {
+		ackTimeout = time.Duration(req.AckTimeoutMs) * time.Millisecond
+	}
+
+	ctx := ss.Context()
+
+	for ctx.Err() == nil {
+		inflight, e := g.Pending(ctx)
+		if e != nil {
+			s.Errorf("rpc inflight failed topic: %s, err: %s", req.Topic, e)
+		}
+
+		if inflight >= maxInflight {
+			// wait for acks
+			sleep(ctx, 100*time.Millisecond)
+			continue
+		}
+
+		msgs, e := g.ClaimIdle(ctx, ackTimeout, maxInflight-inflight)
+		if e == nil && len(msgs) == 0 {
+			msgs, e = g.Fetch(ctx, maxInflight-inflight, 5*time.Second)
+		}
+
+		if e != nil {
+			if ctx.Err() == nil {
+				s.Errorf("rpc subscribe failed topic: %s, group: %s, err: %s", req.Topic, req.Group, e)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+
+		// Send blocks while the client doesn't read, the http2 flow control is the backpressure
+		for _, m := range msgs {
+			if e = ss.Send(toMessage(m)); e != nil {
+				return e
+			}
+		}
+	}
+
+	return toStatus(ctx, ctx.Err())
+}
+
+func (s *Server) Ack(ctx context.Context, req *rsqpb.AckRequest) (*rsqpb.AckResponse, error) {
+	g, e := s.group(ctx, req.Topic, req.Group, req.Consumer)
+	if e != nil {
+		return nil, e
+	}
+	defer s.release(g)
+
+	n, e := g.Ack(ctx, req.EntryIds...)
+	if e != nil {
+		return nil, toStatus(ctx, e)
+	}
+
+	return &rsqpb.AckResponse{Acked: n}, nil
+}
+
+func (s *Server) Nack(ctx context.Context, req *rsqpb.NackRequest) (*rsqpb.NackResponse, error) {
+	g, e := s.group(ctx, req.Topic, req.Group, req.Consumer)
+	if e != nil {
+		return nil, e
+	}
+	defer s.release(g)
+
+	var n int64
+	if req.DeadLetter {
+		n, e = g.DeadLetter(ctx, req.Reason, req.EntryIds...)
+	} else {
+		n, e = g.Nack(ctx, req.EntryIds...)
+	}
+
+	if e != nil {
+		return nil, toStatus(ctx, e)
+	}
+
+	return &rsqpb.NackResponse{Nacked: n}, nil
+}
+
+func toMessage(m *rsq.Message) *rsqpb.Message {
+	return &rsqpb.Message{
+		Topic:         m.Topic,
+		Id:            m.Id,
+		TagId:         m.TagId,
+		Data:          m.Data,
+		Headers:       m.Header,
+		EntryId:       m.EntryId,
+		DeliveryCount: m.DeliveryCount,
+		Batched:       m.Batched,
+	}
+}
+
+// toStatus maps the errors of rsq to grpc status codes
+func toStatus(ctx context.Context, e error) error {
+	var ke *kerror.KError
+
+	switch {
+	case errors.As(e, &ke) && ke.Code() == kerror.UnavailableError.Code():
+		return status.Error(codes.Unavailable, e.Error())
+	case errors.Is(e, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, e.Error())
+	case errors.Is(e, context.Canceled):
+		return status.Error(codes.Canceled, e.Error())
+	}
+
+	return status.Error(codes.Internal, e.Error())
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
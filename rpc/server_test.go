@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/rpc/rsqpb"
+	"github.com/wsk15046/rsq/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestInvalidRequests(t *testing.T) {
+	c, l := test.Dependency()
+
+	s := NewServer(c, l, WithMaxBatch(1), WithAuth(AllowTopics("rsq:rpc_*")))
+	ctx := context.Background()
+
+	if _, e := s.Publish(ctx, &rsqpb.PublishRequest{Topic: "rsq:rpc_test", Id: "a-b"}); status.Code(e) != codes.InvalidArgument {
+		t.Errorf("expect invalid id, got %v", e)
+	}
+
+	if _, e := s.Publish(ctx, &rsqpb.PublishRequest{Topic: "rsq:rpc_test", Id: "1", Tags: []string{"a-b"}}); status.Code(e) != codes.InvalidArgument {
+		t.Errorf("expect invalid tag, got %v", e)
+	}
+
+	if _, e := s.Publish(ctx, &rsqpb.PublishRequest{Id: "1"}); status.Code(e) != codes.InvalidArgument {
+		t.Errorf("expect missing topic, got %v", e)
+	}
+
+	batch := &rsqpb.PublishBatchRequest{Messages: []*rsqpb.PublishRequest{{Id: "1"}, {Id: "2"}}}
+	if _, e := s.PublishBatch(ctx, batch); status.Code(e) != codes.InvalidArgument {
+		t.Errorf("expect batch too large, got %v", e)
+	}
+
+	resp, e := s.PublishBatch(ctx, &rsqpb.PublishBatchRequest{Messages: []*rsqpb.PublishRequest{{Topic: "rsq:rpc_test"}}})
+	if e != nil || len(resp.Results) != 1 || resp.Results[0].Code != int32(codes.InvalidArgument) {
+		t.Errorf("expect a failed result, got %v %v", resp, e)
+	}
+
+	if _, e := s.Ack(ctx, &rsqpb.AckRequest{Topic: "rsq:rpc_test"}); status.Code(e) != codes.InvalidArgument {
+		t.Errorf("expect missing group, got %v", e)
+	}
+
+	if _, e := s.Publish(ctx, &rsqpb.PublishRequest{Topic: "rsq:other", Id: "1"}); status.Code(e) != codes.PermissionDenied {
+		t.Errorf("expect a topic not allowed, got %v", e)
+	}
+
+	if _, e := s.Ack(ctx, &rsqpb.AckRequest{Topic: "rsq:other", Group: "g", Consumer: "c"}); status.Code(e) != codes.PermissionDenied {
+		t.Errorf("expect a topic not allowed, got %v", e)
+	}
+
+	if _, e := NewServer(c, l).Publish(ctx, &rsqpb.PublishRequest{Topic: "rsq:rpc_test", Id: "1"}); status.Code(e) != codes.PermissionDenied {
+		t.Errorf("expect requests refused without authorizer, got %v", e)
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{kerror.UnavailableError.Msgf("topic: %s", "t"), codes.Unavailable},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{errors.New("boom"), codes.Internal},
+	}
+
+	for _, tc := range cases {
+		if code := status.Code(toStatus(ctx, tc.err)); code != tc.code {
+			t.Errorf("%v expect %s, got %s", tc.err, tc.code, code)
+		}
+	}
+}
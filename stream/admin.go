@@ -0,0 +1,510 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/redisop"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Admin manages topics, groups and consumers
+type Admin struct {
+	rsq.ILogger
+	client redis.UniversalClient
+}
+
+// Entry is a stream entry with the messages batched in it
+type Entry struct {
+	Id       string
+	Messages []*rsq.Message
+}
+
+// PendingEntry is an entry delivered to a consumer of a group but not acked
+type PendingEntry struct {
+	Id            string
+	Consumer      string
+	Idle          time.Duration
+	DeliveryCount int64
+}
+
+// DeadLetter is an entry moved to the dead letter queue of a topic
+type DeadLetter struct {
+	*Entry
+	Origin string //entry id in the topic
+	Group  string
+	Reason string
+}
+
+func NewAdmin(cl redis.UniversalClient, l rsq.ILogger) *Admin {
+	return &Admin{ILogger: l, client: cl}
+}
+
+// Topics lists the streams whose key matches pattern, all of them when pattern is empty.
+// The dead letter queues and the lanes of the topics are left out.
+func (a *Admin) Topics(ctx context.Context, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	keys, err := scanKeys(ctx, a.client, pattern, "stream")
+	if err != nil {
+		return nil, err
+	}
+
+	topics := keys[:0]
+	for _, key := range keys {
+		if !derivedStream(key) {
+			topics = append(topics, key)
+		}
+	}
+
+	sort.Strings(topics)
+
+	return topics, nil
+}
+
+func (a *Admin) StreamInfo(ctx context.Context, topic string) (*StreamInfo, error) {
+	return xInfoStream(ctx, a.client, topic)
+}
+
+func (a *Admin) Len(ctx context.Context, topic string) (int64, error) {
+	return a.client.XLen(ctx, topic).Result()
+}
+
+func (a *Admin) Groups(ctx context.Context, topic string) ([]*GroupInfo, error) {
+	return xInfoGroups(ctx, a.client, topic)
+}
+
+func (a *Admin) Consumers(ctx context.Context, topic, group string) ([]*ConsumerInfo, error) {
+	return xInfoConsumers(ctx, a.client, topic, group)
+}
+
+// CreateGroup creates group reading from start ("$" for new entries, "0" for all of them),
+// the topic is created if it doesn't exist.
+func (a *Admin) CreateGroup(ctx context.Context, topic, group, start string) error {
+	return a.client.XGroupCreateMkStream(ctx, topic, group, start).Err()
+}
+
+func (a *Admin) DeleteGroup(ctx context.Context, topic, group string) error {
+	return a.client.XGroupDestroy(ctx, topic, group).Err()
+}
+
+// RemoveConsumer deletes consumer from group, its pending entries are dropped and their count returned
+func (a *Admin) RemoveConsumer(ctx context.Context, topic, group, consumer string) (int64, error) {
+	return a.client.XGroupDelConsumer(ctx, topic, group, consumer).Result()
+}
+
+// RemoveDeadConsumers deletes the consumers idle longer than maxIdle,
+// consumers still owning pending entries are kept so that they can be claimed first.
+func (a *Admin) RemoveDeadConsumers(ctx context.Context, topic, group string, maxIdle time.Duration) ([]string, error) {
+	consumers, err := xInfoConsumers(ctx, a.client, topic, group)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, c := range consumers {
+		if c.Idle < maxIdle || c.Pending > 0 {
+			continue
+		}
+
+		if _, err = a.RemoveConsumer(ctx, topic, group, c.Name); err != nil {
+			return removed, err
+		}
+
+		a.Infof("remove dead consumer topic: %s, group: %s, name: %s, idle: %s", topic, group, c.Name, c.Idle)
+		removed = append(removed, c.Name)
+	}
+
+	return removed, nil
+}
+
+// ResetGroup sets the last delivered id of group, entries after it will be delivered again
+func (a *Admin) ResetGroup(ctx context.Context, topic, group, id string) error {
+	return a.client.XGroupSetID(ctx, topic, group, id).Err()
+}
+
+// Trim keeps the last maxLen entries of topic and returns the number of deleted entries
+func (a *Admin) Trim(ctx context.Context, topic string, maxLen int64, approx bool) (int64, error) {
+	if approx {
+		return a.client.XTrimMaxLenApprox(ctx, topic, maxLen, 0).Result()
+	}
+
+	return a.client.XTrimMaxLen(ctx, topic, maxLen).Result()
+}
+
+// Peek returns up to count entries of topic from start ("-" for the first one)
+func (a *Admin) Peek(ctx context.Context, topic, start string, count int64) ([]*Entry, error) {
+	if start == "" {
+		start = "-"
+	}
+
+	msgs, err := a.client.XRangeN(ctx, topic, start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(msgs))
+	for _, m := range msgs {
+		entries = append(entries, DecodeEntry(topic, m, a.ILogger))
+	}
+
+	return entries, nil
+}
+
+// Delete removes entries of topic and returns the number of deleted ones
+func (a *Admin) Delete(ctx context.Context, topic string, ids ...string) (int64, error) {
+	return a.client.XDel(ctx, topic, ids...).Result()
+}
+
+// Tail calls fn with the entries of topic after from ("$" for new entries) until ctx is done or fn fails
+func (a *Admin) Tail(ctx context.Context, topic, from string, fn func(e *Entry) error) error {
+	if from == "" || from == "$" {
+		//"$" is resolved once, read again after a timeout it would skip the entries added in between
+		id, err := lastEntryId(ctx, a.client, topic)
+		if err != nil {
+			return err
+		}
+		from = id
+	}
+
+	for {
+		data, err := a.client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{topic, from},
+			Count:   readCount,
+			Block:   blockRead * time.Millisecond,
+		}).Result()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			return err
+		}
+
+		for _, result := range data {
+			for _, m := range result.Messages {
+				if err = fn(DecodeEntry(topic, m, a.ILogger)); err != nil {
+					return err
+				}
+				from = m.ID
+			}
+		}
+	}
+}
+
+// Stats returns the stat reported by the consumers of topic, keyed by their full name
+func (a *Admin) Stats(ctx context.Context, topic string) (map[string]*ConsumerStat, error) {
+	r := redisop.NewRedisHash[ConsumerStat](a.client, a.ILogger)
+
+	m, err := r.GetAll(streamStatKey(topic))
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Pending lists up to count pending entries of group, only those of consumer if it isn't empty
+func (a *Admin) Pending(ctx context.Context, topic, group, consumer string, count int64) ([]*PendingEntry, error) {
+	ps, err := a.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   topic,
+		Group:    group,
+		Start:    "-",
+		End:      "+",
+		Count:    count,
+		Consumer: consumer,
+	}).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	entries := make([]*PendingEntry, 0, len(ps))
+	for _, p := range ps {
+		entries = append(entries, &PendingEntry{
+			Id:            p.ID,
+			Consumer:      p.Consumer,
+			Idle:          p.Idle,
+			DeliveryCount: p.RetryCount,
+		})
+	}
+
+	return entries, nil
+}
+
+// Claim transfers the pending entries idle longer than minIdle to consumer and returns the claimed ids
+func (a *Admin) Claim(ctx context.Context, topic, group, consumer string, minIdle time.Duration, ids ...string) ([]string, error) {
+	return a.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   topic,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+}
+
+// DeadLetters returns up to count entries of the dead letter queue of topic from start
+func (a *Admin) DeadLetters(ctx context.Context, topic, start string, count int64) ([]*DeadLetter, error) {
+	if start == "" {
+		start = "-"
+	}
+
+	msgs, err := a.client.XRangeN(ctx, streamDLQKey(topic), start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*DeadLetter, 0, len(msgs))
+	for _, m := range msgs {
+		letters = append(letters, &DeadLetter{
+			Entry:  DecodeEntry(topic, m, a.ILogger),
+			Origin: replyString(m.Values[dlqFieldOrigin]),
+			Group:  replyString(m.Values[dlqFieldGroup]),
+			Reason: replyString(m.Values[dlqFieldReason]),
+		})
+	}
+
+	return letters, nil
+}
+
+// DeadLetter moves pending entries of group to the dead letter queue of topic and acks them
+func (a *Admin) DeadLetter(ctx context.Context, topic, group, reason string, ids ...string) (int64, error) {
+	return deadLetter(ctx, a.client, topic, group, reason, 0, ids...)
+}
+
+// AddDeadLetter adds msgs to the dead letter queue of topic in one entry without group, for the messages failed
+// before being published. A message without tag is a broadcast one. It returns the id of the dead letter.
+func (a *Admin) AddDeadLetter(ctx context.Context, topic, reason string, msgs ...*rsq.Message) (string, error) {
+	values := map[string]interface{}{dlqFieldReason: reason}
+	for i, msg := range msgs {
+		tag := msg.TagId
+		if tag == "" {
+			tag = tagIdAll
+		}
+		appendMsg(values, i, &MsgNode{Id: msg.Id, TagId: tag, Data: msg.Data, Header: msg.Header})
+	}
+
+	return a.client.XAdd(ctx, &redis.XAddArgs{Stream: streamDLQKey(topic), Values: values}).Result()
+}
+
+// redriveScript moves a dead letter back to its topic unless another redrive already did,
+// the topic is trimmed to about ARGV[2] entries, 0 doesn't trim it
+const redriveScript = `
+	if #redis.call("XRANGE", KEYS[2], ARGV[1], ARGV[1]) == 0 then
+		return 0
+	end
+	if tonumber(ARGV[2]) > 0 then
+		redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[2], "*", unpack(ARGV, 3))
+	else
+		redis.call("XADD", KEYS[1], "*", unpack(ARGV, 3))
+	end
+	return redis.call("XDEL", KEYS[2], ARGV[1])
+`
+
+var redriveEntry = redis.NewScript(redriveScript)
+
+// Redrive publishes dead letters back to topic, all of them when ids is empty, and trims topic to about maxLen entries,
+// maxLen 0 doesn't trim it. It returns the number of redriven entries.
+// Every group of topic reads the redriven entries again, RedriveGroup hands them to the group which dead lettered them only.
+func (a *Admin) Redrive(ctx context.Context, topic string, maxLen int64, ids ...string) (int64, error) {
+	dlq := streamDLQKey(topic)
+
+	msgs, err := a.deadLetterEntries(ctx, dlq, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	n := int64(0)
+	for _, m := range msgs {
+		args := make([]interface{}, 0, 2+2*len(m.Values))
+		args = append(args, m.ID, maxLen)
+		for k, v := range m.Values {
+			if !strings.HasPrefix(k, "_") || strings.HasPrefix(k, msgHeaderPrefix) {
+				args = append(args, k, v)
+			}
+		}
+
+		//the entry is added to the topic and deleted from the queue at once
+		moved, err := redriveEntry.Run(ctx, a.client, []string{topic, dlq}, args...).Int64()
+		if err != nil {
+			return n, err
+		}
+
+		n += moved
+	}
+
+	return n, nil
+}
+
+// deadLetter copies entries to the dead letter queue before acking them, maxLen 0 doesn't trim the queue
+func deadLetter(ctx context.Context, client redis.UniversalClient, topic, group, reason string, maxLen int64, ids ...string) (int64, error) {
+	n := int64(0)
+
+	for _, id := range ids {
+		msgs, err := client.XRangeN(ctx, topic, id, id, 1).Result()
+		if err != nil {
+			return n, err
+		}
+
+		// the entry may have been trimmed, it is acked anyway
+		if len(msgs) > 0 {
+			values := msgs[0].Values
+			values[dlqFieldOrigin] = id
+			values[dlqFieldGroup] = group
+			values[dlqFieldReason] = reason
+
+			if err = client.XAdd(ctx, &redis.XAddArgs{Stream: streamDLQKey(topic), MaxLen: maxLen, Approx: true, Values: values}).Err(); err != nil {
+				return n, err
+			}
+		}
+
+		if err = client.XAck(ctx, topic, group, id).Err(); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// DecodeEntry decodes the messages batched in a stream entry of topic
+func DecodeEntry(topic string, m redis.XMessage, l rsq.ILogger) *Entry {
+	e := &Entry{Id: m.ID}
+
+	for _, node := range preTreatMsgs(m.Values, l) {
+		if node == nil || node.Id == msgIdCreateTopic {
+			continue
+		}
+
+		e.Messages = append(e.Messages, &rsq.Message{
+			Id:      node.Id,
+			TagId:   node.TagId,
+			Data:    node.Data,
+			Header:  node.Header,
+			Topic:   topic,
+			EntryId: m.ID,
+		})
+	}
+
+	return e
+}
+
+// scanKeys scans every master of a cluster
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern, keyType string) ([]string, error) {
+	var mutex sync.Mutex
+	var keys []string
+
+	scan := func(ctx context.Context, c redis.UniversalClient) error {
+		iter := c.ScanType(ctx, 0, pattern, 1000, keyType).Iterator()
+		for iter.Next(ctx) {
+			mutex.Lock()
+			keys = append(keys, iter.Val())
+			mutex.Unlock()
+		}
+
+		return iter.Err()
+	}
+
+	if cc, ok := client.(*redis.ClusterClient); ok {
+		err := cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+
+		return keys, err
+	}
+
+	return keys, scan(ctx, client)
+}
+
+// RedriveGroup hands dead letters back to the group which dead lettered them, all of them when ids is empty,
+// through the delay queue of the group. Only the groups with a RetryPolicy poll their delay queue,
+// a message failed again goes through the policy and is dead lettered at the end.
+// It returns the number of redriven entries, those without group are left in the queue.
+func (a *Admin) RedriveGroup(ctx context.Context, topic string, ids ...string) (int64, error) {
+	dlq := streamDLQKey(topic)
+
+	msgs, err := a.deadLetterEntries(ctx, dlq, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	n := int64(0)
+	now := float64(time.Now().UnixMilli())
+	for _, m := range msgs {
+		group := replyString(m.Values[dlqFieldGroup])
+		if group == "" {
+			continue
+		}
+
+		ms, _, err := parseStreamId(m.ID)
+		if err != nil {
+			return n, err
+		}
+
+		//the records of a letter are the same for each redrive, a repeated redrive doesn't queue them twice
+		var members []*redis.Z
+		for _, msg := range DecodeEntry(topic, m, a.ILogger).Messages {
+			if msg.TagId != tagIdAll && msg.TagId != group {
+				continue
+			}
+
+			b, err := json.Marshal(&retryRecord{
+				Id:      msg.Id,
+				TagId:   msg.TagId,
+				Data:    msg.Data,
+				Header:  msg.Header,
+				EntryId: replyString(m.Values[dlqFieldOrigin]),
+				Due:     ms,
+			})
+			if err != nil {
+				return n, kerror.JsonError.Msg(err.Error())
+			}
+			members = append(members, &redis.Z{Score: now, Member: b})
+		}
+
+		//the queue of the group is in another slot, the letter is deleted once the messages are queued
+		if len(members) > 0 {
+			if err = a.client.ZAdd(ctx, streamRetryKey(topic, group), members...).Err(); err != nil {
+				return n, err
+			}
+		}
+
+		deleted, err := a.client.XDel(ctx, dlq, m.ID).Result()
+		if err != nil {
+			return n, err
+		}
+
+		n += deleted
+	}
+
+	return n, nil
+}
+
+// deadLetterEntries returns the entries ids of the dead letter queue dlq, all of them when ids is empty
+func (a *Admin) deadLetterEntries(ctx context.Context, dlq string, ids []string) ([]redis.XMessage, error) {
+	if len(ids) == 0 {
+		return a.client.XRange(ctx, dlq, "-", "+").Result()
+	}
+
+	var msgs []redis.XMessage
+	for _, id := range ids {
+		m, err := a.client.XRangeN(ctx, dlq, id, id, 1).Result()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m...)
+	}
+
+	return msgs, nil
+}
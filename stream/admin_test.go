@@ -0,0 +1,237 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEntryId(t *testing.T) {
+	for id, want := range map[string][2]int64{"1700000000000-5": {1700000000000, 5}, "42": {42, 0}, "0-0": {0, 0}} {
+		ms, seq, err := parseStreamId(id)
+		if err != nil || ms != want[0] || seq != want[1] {
+			t.Errorf("%s parsed as %d %d %v", id, ms, seq, err)
+		}
+	}
+
+	for _, id := range []string{"", "x", "1-x", "-1"} {
+		if _, _, err := parseStreamId(id); err == nil {
+			t.Errorf("expect %q invalid", id)
+		}
+	}
+
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-0", "1-1", -1},
+		{"2-0", "1-9", 1},
+		{"10-0", "9-0", 1},
+		{"1", "1-0", 0},
+		{"x", "0-0", -1},
+		{"0-0", "x", 1},
+		{"x", "y", 0},
+	}
+	for _, c := range cases {
+		if got := CompareEntryId(c.a, c.b); got != c.want {
+			t.Errorf("compare %s %s: expect %d, got %d", c.a, c.b, c.want, got)
+		}
+	}
+}
+
+func TestDerivedStream(t *testing.T) {
+	for _, topic := range []string{"t", "rsq:t", "{rsq}:t"} {
+		if derivedStream(topic) {
+			t.Errorf("expect %s listed", topic)
+		}
+
+		for _, key := range []string{streamDLQKey(topic), LaneTopic(topic, 2)} {
+			if !derivedStream(key) {
+				t.Errorf("expect %s left out", key)
+			}
+		}
+	}
+
+	for _, key := range []string{"rsq:t_dlq", "rsq:t_lane_2", "{rsq}:t_lane_x"} {
+		if derivedStream(key) {
+			t.Errorf("expect %s listed", key)
+		}
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	_, l := test.Dependency()
+
+	m := redis.XMessage{ID: "1-0", Values: map[string]interface{}{
+		"1-0-a":            "x",
+		"2-1-b":            "y",
+		"_hdr-1":           `{"k":"v"}`,
+		msgIdCreateTopic:   "",
+		"invalid":          "z",
+		dlqFieldOrigin:     "0-1",
+		dlqFieldReason:     "failed",
+		"_hdr-x":           "{}",
+		"createTopic-2-$":  "",
+		"3-notanindex-tag": "w",
+	}}
+
+	e := DecodeEntry("rsq:decode_test", m, l)
+	if e.Id != "1-0" || len(e.Messages) != 2 {
+		t.Fatalf("expect 2 messages, got %+v", e.Messages)
+	}
+
+	first, second := e.Messages[0], e.Messages[1]
+	if first.Id != "1" || first.TagId != "a" || string(first.Data) != "x" || first.Header != nil {
+		t.Errorf("unexpected first message %+v", first)
+	}
+
+	if second.Id != "2" || second.TagId != "b" || string(second.Data) != "y" || second.Header["k"] != "v" {
+		t.Errorf("unexpected second message %+v", second)
+	}
+
+	if first.Topic != "rsq:decode_test" || first.EntryId != "1-0" {
+		t.Errorf("expect the topic and the entry of the message, got %+v", first)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	topic := "rsq:dead_letter_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	a := NewAdmin(c, l)
+
+	c.Del(ctx, topic, streamDLQKey(topic))
+	if err := a.CreateGroup(ctx, topic, "g", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]interface{}{}
+	appendMsg(values, 0, &MsgNode{Id: "1", TagId: "t", Data: []byte("x")})
+	id := c.XAdd(ctx, &redis.XAddArgs{Stream: topic, Values: values}).Val()
+
+	//an entry pending in the group, and one trimmed from the topic while pending
+	c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "n", Streams: []string{topic, ">"}, Count: 1, Block: -1})
+
+	n, err := a.DeadLetter(ctx, topic, "g", "manual", id, "1-1")
+	if err != nil || n != 2 {
+		t.Fatalf("expect 2 entries dead lettered, got %d %v", n, err)
+	}
+
+	if p := c.XPending(ctx, topic, "g").Val(); p.Count != 0 {
+		t.Errorf("expect the entries acked, %d pending", p.Count)
+	}
+
+	letters, err := a.DeadLetters(ctx, topic, "", 10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("expect the existing entry copied, got %v %v", letters, err)
+	}
+
+	dl := letters[0]
+	if dl.Origin != id || dl.Group != "g" || dl.Reason != "manual" || len(dl.Messages) != 1 || string(dl.Messages[0].Data) != "x" {
+		t.Errorf("unexpected dead letter %+v %+v", dl, dl.Messages)
+	}
+
+	//the entry stays in the topic
+	if n := c.XLen(ctx, topic).Val(); n != 1 {
+		t.Errorf("expect the entry kept in the topic, got %d", n)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	topic := "rsq:redrive_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	a := NewAdmin(c, l)
+
+	c.Del(ctx, topic, streamDLQKey(topic))
+
+	msg := &rsq.Message{Id: "1", TagId: "t", Data: []byte("x"), Topic: topic, EntryId: "1-0"}
+	if err := deadLetterMessage(ctx, c, msg, "g", "failed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := a.DeadLetters(ctx, topic, "", 10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("expect a dead letter, got %v %v", letters, err)
+	}
+
+	if n, err := a.Redrive(ctx, topic, 100, letters[0].Id); err != nil || n != 1 {
+		t.Fatalf("expect the dead letter redriven, got %d %v", n, err)
+	}
+
+	//a second redrive of the same letter doesn't publish it again
+	if n, err := a.Redrive(ctx, topic, 100, letters[0].Id); err != nil || n != 0 {
+		t.Errorf("expect nothing redriven again, got %d %v", n, err)
+	}
+
+	if n := c.XLen(ctx, streamDLQKey(topic)).Val(); n != 0 {
+		t.Errorf("expect the queue empty, got %d", n)
+	}
+
+	entries, err := c.XRange(ctx, topic, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expect the entry in the topic, got %v %v", entries, err)
+	}
+
+	e := DecodeEntry(topic, entries[0], l)
+	if len(e.Messages) != 1 || e.Messages[0].Id != "1" || string(e.Messages[0].Data) != "x" {
+		t.Errorf("unexpected redriven entry %+v", e.Messages)
+	}
+
+	if _, ok := entries[0].Values[dlqFieldOrigin]; ok {
+		t.Error("expect the dead letter fields dropped")
+	}
+}
+
+func TestRedriveGroup(t *testing.T) {
+	topic := "rsq:redrive_group_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	a := NewAdmin(c, l)
+
+	c.Del(ctx, topic, streamDLQKey(topic), streamRetryKey(topic, "g"))
+
+	msg := &rsq.Message{Id: "1", TagId: tagIdAll, Data: []byte("x"), Topic: topic, EntryId: "1-0"}
+	if err := deadLetterMessage(ctx, c, msg, "g", "failed", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := a.RedriveGroup(ctx, topic); err != nil || n != 1 {
+		t.Fatalf("expect the dead letter redriven, got %d %v", n, err)
+	}
+
+	//the other groups don't read it again
+	if n := c.XLen(ctx, topic).Val(); n != 0 {
+		t.Errorf("expect nothing added to the topic, got %d", n)
+	}
+
+	if n := c.XLen(ctx, streamDLQKey(topic)).Val(); n != 0 {
+		t.Errorf("expect the queue empty, got %d", n)
+	}
+
+	var handled int32
+	g := NewGroup(topic, "g", "n", c, l, WithRetry(RetryPolicy{Delayed: 1}), WithBlock(100*time.Millisecond))
+	g.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		if msg.Id == "1" && string(msg.Data) == "x" && msg.EntryId == "1-0" {
+			atomic.AddInt32(&handled, 1)
+		}
+		return nil
+	})
+	g.Subscribe()
+	defer g.Stop()
+
+	time.Sleep(time.Second)
+
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("expect the message handled by the group once, got %d", n)
+	}
+
+	if n := c.ZCard(ctx, streamRetryKey(topic, "g")).Val(); n != 0 {
+		t.Errorf("expect the delay queue empty, got %d", n)
+	}
+}
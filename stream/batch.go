@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+)
+
+// batch packs the buffered messages of a producer into entries, the sealed entries are written in one pipeline
+type batch struct {
+	size     int
+	maxBytes int
+	depth    int
+
+	entries []map[string]interface{}
+	counts  []int
+
+	m     map[string]interface{}
+	index int
+	bytes int
+}
+
+func newBatch(o *options) *batch {
+	//an entry per message, the batch size bounds the entries of a round trip
+	if o.singleEntry {
+		return &batch{size: 1, depth: o.batchSize}
+	}
+
+	return &batch{size: o.batchSize, maxBytes: o.batchBytes, depth: o.pipeline}
+}
+
+func (b *batch) empty() bool {
+	return b.index == 0 && len(b.entries) == 0
+}
+
+// add packs node into the current entry and tells whether the batch is full and must be flushed
+func (b *batch) add(node *MsgNode) bool {
+	n := msgSize(node)
+
+	if b.index > 0 && b.maxBytes > 0 && b.bytes+n > b.maxBytes {
+		b.seal()
+	}
+
+	if b.m == nil {
+		b.m = make(map[string]interface{}, b.size)
+	}
+
+	appendMsg(b.m, b.index, node)
+	b.index++
+	b.bytes += n
+
+	if b.index >= b.size {
+		b.seal()
+	}
+
+	return len(b.entries) >= b.depth
+}
+
+// seal closes the current entry
+func (b *batch) seal() {
+	if b.index == 0 {
+		return
+	}
+
+	b.entries = append(b.entries, b.m)
+	b.counts = append(b.counts, b.index)
+
+	b.m = nil
+	b.index = 0
+	b.bytes = 0
+}
+
+// take returns the entries of the batch and resets it
+func (b *batch) take() (entries []map[string]interface{}, counts []int) {
+	b.seal()
+
+	entries, counts = b.entries, b.counts
+	b.entries, b.counts = nil, nil
+
+	return entries, counts
+}
+
+// msgSize estimates the bytes of node in an entry
+func msgSize(node *MsgNode) int {
+	n := len(node.Id) + len(node.TagId) + len(node.Data) + 8
+	for k, v := range node.Header {
+		n += len(k) + len(v) + 6
+	}
+
+	return n
+}
+
+// flush writes the entries of b, several entries are pipelined in one round trip
+func (p *producer) flush(b *batch) {
+	entries, counts := b.take()
+
+	switch len(entries) {
+	case 0:
+		return
+	case 1:
+		p.send(entries[0], counts[0])
+		return
+	}
+
+	_, errs := p.xAdds(context.Background(), entries)
+
+	for i, count := range counts {
+		if err := errs[i]; err != nil {
+			p.flow.failed.Add(int64(count))
+			p.Errorf("MQProducer publish failed error: %s", err.Error())
+			continue
+		}
+
+		p.flow.published.Add(int64(count))
+	}
+}
+
+// xAdds writes entries in one round trip and returns the id or the error of each one
+func (p *producer) xAdds(ctx context.Context, entries []map[string]interface{}) ([]string, []error) {
+	cmds, _ := p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, m := range entries {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: p.topic,
+				MaxLen: p.maxLen,
+				Approx: true,
+				ID:     "",
+				Values: m,
+			})
+		}
+		return nil
+	})
+
+	ids := make([]string, len(entries))
+	errs := make([]error, len(entries))
+
+	//each command holds its own error
+	for i, cmd := range cmds {
+		ids[i], errs[i] = cmd.(*redis.StringCmd).Result()
+	}
+
+	return ids, errs
+}
@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"github.com/wsk15046/rsq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const attrBatchCount = attribute.Key("messaging.batch.message_count")
+
+// errMissingResult fails the messages a batch handler returned no error for
+var errMissingResult = errors.New("no result of the batch handler")
+
+// handlerBatch gathers the messages handed to a batch handler.
+// The messages of an entry may be split between batches, the entry is done once all of them are handled.
+type handlerBatch struct {
+	size   int
+	wait   time.Duration
+	msgs   []*rsq.Message
+	done   []string        //entries whose messages are all in the batch or in the previous ones
+	failed map[string]bool //entries with a failed message
+	first  time.Time
+}
+
+func newHandlerBatch(o *options) *handlerBatch {
+	return &handlerBatch{size: o.handlerBatchSize, wait: o.handlerBatchWait, failed: make(map[string]bool)}
+}
+
+// add adds the messages of an entry, flush is called each time the batch is full
+func (b *handlerBatch) add(entryId string, msgs []*rsq.Message, flush func()) {
+	for _, msg := range msgs {
+		if len(b.msgs) >= b.size {
+			flush()
+		}
+
+		if len(b.msgs) == 0 {
+			b.first = time.Now()
+		}
+		b.msgs = append(b.msgs, msg)
+	}
+
+	b.done = append(b.done, entryId)
+
+	//an entry without message for the consumer is done at once
+	if len(b.msgs) == 0 || len(b.msgs) >= b.size {
+		flush()
+	}
+}
+
+// due tells whether the first message waited long enough
+func (b *handlerBatch) due() bool {
+	return len(b.msgs) > 0 && time.Since(b.first) >= b.wait
+}
+
+// block shortens the block of a read so that the first message waits no longer than wait
+func (b *handlerBatch) block(block time.Duration) time.Duration {
+	if len(b.msgs) == 0 {
+		return block
+	}
+
+	left := b.wait - time.Since(b.first)
+	if left < time.Millisecond {
+		left = time.Millisecond
+	}
+
+	if block == 0 || left < block {
+		return left
+	}
+
+	return block
+}
+
+// reset empties the batch once its messages are handled with errs, it returns the done entries without failed message
+func (b *handlerBatch) reset(errs []error) []string {
+	for i, msg := range b.msgs {
+		if errs != nil && (i >= len(errs) || errs[i] != nil) {
+			b.failed[msg.EntryId] = true
+		}
+	}
+
+	var ok []string
+	for _, id := range b.done {
+		if b.failed[id] {
+			delete(b.failed, id)
+			continue
+		}
+		ok = append(ok, id)
+	}
+
+	b.msgs = nil
+	b.done = nil
+
+	return ok
+}
+
+// handleBatch calls h in a span linked to the contexts extracted from the message headers
+func handleBatch(tracer trace.Tracer, h rsq.BatchHandler, msgs []*rsq.Message, group string, c rsq.IMQConsumer, l rsq.ILogger) []error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.Header) == 0 {
+			continue
+		}
+
+		sc := trace.SpanContextFromContext(tracePropagator.Extract(context.Background(), propagation.MapCarrier(msg.Header)))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	attrs := []attribute.KeyValue{
+		attrSystem.String("rsq"),
+		attrTopic.String(c.Topic()),
+		attrBatchCount.Int(len(msgs)),
+	}
+	if group != "" {
+		attrs = append(attrs, attrGroup.String(group))
+	}
+
+	ctx, span := tracer.Start(context.Background(), c.Topic()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	errs := h(ctx, msgs, c)
+	if errs == nil {
+		return nil
+	}
+
+	if len(errs) != len(msgs) {
+		l.Errorf("batch handler returned %d errors for %d messages, topic: %s", len(errs), len(msgs), c.Topic())
+	}
+
+	failed := 0
+	for i, msg := range msgs {
+		var err error
+		if i < len(errs) {
+			err = errs[i]
+		} else {
+			err = errMissingResult
+		}
+
+		if err != nil {
+			failed++
+			span.RecordError(err)
+			l.Errorf("handle message failed, topic: %s, id: %s, entry: %s, attempt: %d, err: %s", msg.Topic, msg.Id, msg.EntryId, msg.Attempt, err)
+		}
+	}
+
+	if failed > 0 {
+		span.SetStatus(codes.Error, "failed messages")
+	}
+
+	return errs
+}
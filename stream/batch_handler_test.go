@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"errors"
+	"github.com/wsk15046/rsq"
+	"testing"
+	"time"
+)
+
+func TestHandlerBatch(t *testing.T) {
+	b := newHandlerBatch(newOptions(WithHandlerBatch(2, time.Hour)))
+
+	var acked []string
+	flushes := 0
+	flush := func() {
+		flushes++
+		errs := make([]error, len(b.msgs))
+		for i, msg := range b.msgs {
+			if string(msg.Data) == "bad" {
+				errs[i] = errors.New("bad")
+			}
+		}
+		acked = append(acked, b.reset(errs)...)
+	}
+
+	msg := func(entryId, data string) *rsq.Message {
+		return &rsq.Message{EntryId: entryId, Data: []byte(data)}
+	}
+
+	//the entry 1 spans two batches and fails in the second one
+	b.add("1", []*rsq.Message{msg("1", "a"), msg("1", "b"), msg("1", "bad")}, flush)
+	b.add("2", nil, flush)
+	b.add("3", []*rsq.Message{msg("3", "c")}, flush)
+
+	if flushes != 2 || len(acked) != 2 || acked[0] != "2" || acked[1] != "3" {
+		t.Fatalf("expect the entries 2 and 3 acked, got %d flushes, acks %v", flushes, acked)
+	}
+
+	b.add("4", []*rsq.Message{msg("4", "d")}, flush)
+
+	if b.due() || b.block(time.Second) != time.Second || len(b.msgs) != 1 {
+		t.Error("expect the batch to wait")
+	}
+
+	if len(b.failed) != 0 {
+		t.Errorf("expect the failures cleared, got %v", b.failed)
+	}
+}
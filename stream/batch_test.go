@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	b := newBatch(newOptions(WithBatchSize(3), WithMaxBatchBytes(100), WithPipeline(2)))
+
+	if !b.empty() {
+		t.Fatal("expect an empty batch")
+	}
+
+	//3 small messages fill the first entry
+	for _, id := range []string{"1", "2", "3"} {
+		if b.add(&MsgNode{Id: id, TagId: "a", Data: []byte("x")}) {
+			t.Fatalf("unexpected full batch at %s", id)
+		}
+	}
+
+	//the second message exceeds the max bytes of the entry and fills the pipeline
+	if b.add(&MsgNode{Id: "4", TagId: "a", Data: make([]byte, 60)}) {
+		t.Fatal("unexpected full batch at 4")
+	}
+	if !b.add(&MsgNode{Id: "5", TagId: "a", Data: make([]byte, 60)}) {
+		t.Fatal("expect a full batch at 5")
+	}
+
+	b.add(&MsgNode{Id: "6", TagId: "a", Data: []byte("x")})
+
+	entries, counts := b.take()
+	if len(entries) != 3 || counts[0] != 3 || counts[1] != 1 || counts[2] != 2 {
+		t.Fatalf("unexpected entries %v", counts)
+	}
+
+	if _, ok := entries[2]["5-0-a"]; !ok {
+		t.Errorf("expect message 5 first in the last entry, got %v", entries[2])
+	}
+
+	if !b.empty() {
+		t.Error("expect an empty batch after take")
+	}
+}
+
+func TestSingleEntryBatch(t *testing.T) {
+	b := newBatch(newOptions(WithSingleEntry(true), WithBatchSize(2)))
+
+	if b.add(&MsgNode{Id: "1", TagId: "a"}) {
+		t.Fatal("unexpected full batch at 1")
+	}
+	if !b.add(&MsgNode{Id: "2", TagId: "b"}) {
+		t.Fatal("expect a full batch at 2")
+	}
+
+	entries, counts := b.take()
+	if len(entries) != 2 || counts[0] != 1 || counts[1] != 1 {
+		t.Fatalf("expect an entry per message, got %v", counts)
+	}
+
+	if _, ok := entries[1]["2-0-b"]; !ok {
+		t.Errorf("unexpected entry %v", entries[1])
+	}
+}
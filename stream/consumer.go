@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/wsk15046/rsq"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 	"time"
 )
 
@@ -15,13 +17,28 @@ type consumer struct {
 	name    string
 	tagId   string
 	client  redis.UniversalClient
-	handler rsq.ConsumerHandler
+	handler rsq.MessageHandler
 	quit    chan bool
+	opts    *options
+	tracer  trace.Tracer
 
-	cr *ConsumerReporter
+	batchHandler rsq.BatchHandler
+	batch        *handlerBatch
+
+	cr       *ConsumerReporter
+	stopOnce sync.Once
+
+	seekMutex sync.Mutex
+	seekId    string //id the read loop moves to, empty when none
 }
 
-func NewConsumer(topic, name string, cl redis.UniversalClient, l rsq.ILogger) rsq.IMQConsumer {
+func NewConsumer(topic, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) rsq.IMQConsumer {
+	return newConsumer(topic, name, cl, l, opts...)
+}
+
+func newConsumer(topic, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *consumer {
+	o := newOptions(opts...)
+
 	c := &consumer{
 		ILogger: l,
 
@@ -30,17 +47,39 @@ func NewConsumer(topic, name string, cl redis.UniversalClient, l rsq.ILogger) rs
 		tagId:  name,
 		client: cl,
 		quit:   make(chan bool),
+		opts:   o,
+		tracer: o.tracer(),
+		batch:  newHandlerBatch(o),
+	}
+
+	if o.tag != "" {
+		c.tagId = o.tag
 	}
 
 	c.cr = NewConsumerReport(topic, c.tagId, c.FullName(), cl, l)
+	c.cr.interval = o.reportInterval
+	c.cr.onSeek = c.Seek
 
-	createTopic(topic, cl)
+	if e := createTopic(context.Background(), cl, topic); e != nil {
+		l.Warnf("create topic failed [ %s ]", e.Error())
+	}
 
 	return c
 }
 
 func (c *consumer) SetHandler(h rsq.ConsumerHandler) {
+	c.handler = wrapHandler(h)
+	c.batchHandler = nil
+}
+
+func (c *consumer) SetMessageHandler(h rsq.MessageHandler) {
 	c.handler = h
+	c.batchHandler = nil
+}
+
+// SetBatchHandler hands the messages to h in batches, see WithHandlerBatch
+func (c *consumer) SetBatchHandler(h rsq.BatchHandler) {
+	c.batchHandler = h
 }
 
 func (c *consumer) Topic() string {
@@ -57,71 +96,99 @@ func (c *consumer) TagId() string {
 
 func (c *consumer) Subscribe() {
 	c.cr.StartReport()
-	go c.xRead()
+
+	//a read timing out keeps the id, "$" would skip the entries added before the next read
+	id, err := lastEntryId(context.Background(), c.client, c.topic)
+	if err != nil {
+		c.Errorf("MQConsumer:lastEntryId: %s, topic: %s", err, c.topic)
+		id = "$"
+	}
+
+	go c.xRead(id)
 }
 
+// Stop stops the read loop and the reports, it can be called without Subscribe
 func (c *consumer) Stop() {
-	c.quit <- true
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		c.cr.StopReport()
+	})
 }
 
-func (c *consumer) xRead() {
-	ctx := context.Background()
+func (c *consumer) Pause() {
+	c.cr.local.Store(true)
+}
 
-	count := int64(0)
-	id := "$"
+func (c *consumer) Resume() {
+	c.cr.local.Store(false)
+}
+
+// Seek moves the read loop before its next read
+func (c *consumer) Seek(id string) error {
+	if err := validSeekId(id); err != nil {
+		return err
+	}
+
+	c.seekMutex.Lock()
+	defer c.seekMutex.Unlock()
+	c.seekId = id
+
+	return nil
+}
+
+// takeSeek returns the id to move to when Seek was called since the last read
+func (c *consumer) takeSeek() (string, bool) {
+	c.seekMutex.Lock()
+	defer c.seekMutex.Unlock()
+
+	id := c.seekId
+	c.seekId = ""
+
+	return id, id != ""
+}
+
+func (c *consumer) xRead(id string) {
+	ctx := context.Background()
 
 	for {
 		select {
 		case <-c.quit:
+			if len(c.batch.msgs) > 0 {
+				c.flushBatch(ctx)
+			}
 			c.Errorf("consumer quit %s", c.topic)
 			return
 		default:
+			if c.batch.due() {
+				c.flushBatch(ctx)
+			}
+
+			if c.cr.Paused() {
+				time.Sleep(c.cr.interval)
+				continue
+			}
+
+			if seekId, ok := c.takeSeek(); ok {
+				if seekId == "$" {
+					if last, err := lastEntryId(ctx, c.client, c.topic); err == nil {
+						seekId = last
+					}
+				}
+				id = seekId
+			}
+
 			data, errRead := c.client.XRead(ctx, &redis.XReadArgs{
 				Streams: []string{c.topic, id},
-				Count:   10000,
-				Block:   0,
+				Count:   c.opts.readCount,
+				Block:   c.batch.block(c.opts.block),
 			}).Result()
 
 			if data != nil && len(data) > 0 {
-				var ids []string
 				for _, result := range data {
-					for _, message := range result.Messages {
-						if c.handler != nil {
-
-							sortMsg := preTreatMsgs(message.Values, c.ILogger)
-
-							for _, node := range sortMsg {
-								if node == nil {
-									continue
-								}
-
-								pid := node.Id
-								pData := node.Data
-								tagId := node.TagId
-
-								// not mine nor broadcast
-								if tagId != tagIdAll && c.tagId != tagIdAll && c.tagId != tagId {
-									continue
-								}
-
-								//Closure trap, must pass new variables into the closure function
-								if pid != msgIdCreateTopic {
-									c.handler(pid, pData, c)
-								}
-							}
-
-							ids = append(ids, message.ID)
-							id = message.ID
-							count += int64(len(sortMsg))
-						}
+					if lastId := c.consume(ctx, result.Messages); lastId != "" {
+						id = lastId
 					}
 				}
-
-				if l := len(ids); l > 0 {
-					c.cr.Update(ids[l-1], count)
-					count = 0
-				}
-
 			} else {
 				if errRead != redis.Nil {
 					c.Errorf("MQConsumer:xRead:err_read %s", errRead.Error())
@@ -132,6 +199,89 @@ func (c *consumer) xRead() {
 	}
 }
 
+// consume handles the entries read from the topic, it returns the id of the last handled one
+func (c *consumer) consume(ctx context.Context, messages []redis.XMessage) string {
+	var ids []string
+	count := int64(0)
+
+	for _, message := range messages {
+		if c.batchHandler != nil {
+			c.batch.add(message.ID, c.decode(message), func() { c.flushBatch(ctx) })
+			ids = append(ids, message.ID)
+			continue
+		}
+
+		if c.handler == nil {
+			continue
+		}
+
+		msgs := c.decode(message)
+		for _, msg := range msgs {
+			c.opts.throttle(c.topic, c.tagId, c.ILogger)
+			handleMessage(c.tracer, c.handler, msg, "", c, c.ILogger)
+		}
+
+		ids = append(ids, message.ID)
+		count += int64(len(msgs))
+	}
+
+	l := len(ids)
+	if l == 0 {
+		return ""
+	}
+
+	//the batches report their messages once handled
+	if c.batchHandler == nil {
+		c.cr.Update(ids[l-1], count)
+	}
+
+	return ids[l-1]
+}
+
+// decode returns the messages of an entry sent to the consumer
+func (c *consumer) decode(message redis.XMessage) []*rsq.Message {
+	var msgs []*rsq.Message
+
+	for _, node := range preTreatMsgs(message.Values, c.ILogger) {
+		if node == nil || node.Id == msgIdCreateTopic {
+			continue
+		}
+
+		// not mine nor broadcast
+		if node.TagId != tagIdAll && c.tagId != tagIdAll && c.tagId != node.TagId {
+			continue
+		}
+
+		msgs = append(msgs, &rsq.Message{
+			Id:            node.Id,
+			TagId:         node.TagId,
+			Data:          node.Data,
+			Header:        node.Header,
+			Topic:         c.topic,
+			EntryId:       message.ID,
+			DeliveryCount: 1,
+			Attempt:       1,
+		})
+	}
+
+	return msgs
+}
+
+// flushBatch hands the batch to the batch handler, failed messages are only logged by a broadcast consumer
+func (c *consumer) flushBatch(ctx context.Context) {
+	msgs := c.batch.msgs
+	for range msgs {
+		c.opts.throttle(c.topic, c.tagId, c.ILogger)
+	}
+
+	errs := handleBatch(c.tracer, c.batchHandler, msgs, "", c, c.ILogger)
+	c.batch.reset(errs)
+
+	if l := len(msgs); l > 0 {
+		c.cr.Update(msgs[l-1].EntryId, int64(l))
+	}
+}
+
 func (c *consumer) xDel(ctx context.Context, ids []string) (cnt int64, err error) {
 	return c.client.XDel(ctx, c.topic, ids...).Result()
 }
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/redisop"
+	"math"
+	"time"
+)
+
+// ConsumerControl is the state set remotely for a consumer,
+// running consumers load it every report interval.
+type ConsumerControl struct {
+	Paused     bool
+	Seek       string `json:",omitempty"` //entry id the consumer moves to, cleared once applied
+	UpdateTime time.Time
+}
+
+// clearSeekScript replaces the control of a consumer by the one without its seek, or deletes it when empty,
+// only if it is still the one read
+const clearSeekScript = `
+	if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+		return 0
+	end
+	if ARGV[3] == "" then
+		return redis.call("HDEL", KEYS[1], ARGV[1])
+	end
+	return redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+`
+
+var clearSeek = redis.NewScript(clearSeekScript)
+
+// PauseConsumer stops the consumer with fullName from reading topic until it is resumed
+func (a *Admin) PauseConsumer(ctx context.Context, topic, fullName string) error {
+	return a.setControl(topic, fullName, &ConsumerControl{Paused: true})
+}
+
+// SeekConsumer moves the consumer with fullName so that it reads the entries of topic following id, see IMQConsumer.Seek.
+// The consumer applies it at its next report.
+func (a *Admin) SeekConsumer(ctx context.Context, topic, fullName, id string) error {
+	if err := validSeekId(id); err != nil {
+		return err
+	}
+
+	r := redisop.NewRedisHash[ConsumerControl](a.client, a.ILogger)
+
+	m, err := r.GetAll(streamCtlKey(topic))
+	if err != nil {
+		return err
+	}
+
+	ctl := &ConsumerControl{}
+	if old, ok := m[fullName]; ok {
+		ctl.Paused = old.Paused
+	}
+	ctl.Seek = id
+
+	return a.setControl(topic, fullName, ctl)
+}
+
+func (a *Admin) ResumeConsumer(ctx context.Context, topic, fullName string) error {
+	r := redisop.NewRedisHash[ConsumerControl](a.client, a.ILogger)
+
+	if err := r.Del(streamCtlKey(topic), fullName); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Controls returns the state set remotely for the consumers of topic, keyed by their full name
+func (a *Admin) Controls(ctx context.Context, topic string) (map[string]*ConsumerControl, error) {
+	r := redisop.NewRedisHash[ConsumerControl](a.client, a.ILogger)
+
+	m, err := r.GetAll(streamCtlKey(topic))
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (a *Admin) setControl(topic, fullName string, ctl *ConsumerControl) error {
+	r := redisop.NewRedisHash[ConsumerControl](a.client, a.ILogger)
+
+	ctl.UpdateTime = time.Unix(time.Now().Unix(), 0)
+	if err := r.Set(streamCtlKey(topic), fullName, ctl); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SeekId returns the id to seek to so that the entries added from t are read next
+func SeekId(t time.Time) string {
+	ms := t.UnixMilli()
+	if ms <= 0 {
+		return "0"
+	}
+
+	return fmt.Sprintf("%d-%d", ms-1, int64(math.MaxInt64))
+}
+
+func validSeekId(id string) error {
+	if id == "$" {
+		return nil
+	}
+
+	if _, _, err := parseStreamId(id); err != nil {
+		return kerror.SystemError.Msg(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+	"time"
+)
+
+func TestSeekId(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+
+	if id := SeekId(at); CompareEntryId(id, "1700000000000-0") >= 0 || CompareEntryId(id, "1699999999999-5") <= 0 {
+		t.Errorf("unexpected seek id %s", id)
+	}
+
+	if id := SeekId(time.Time{}); id != "0" {
+		t.Errorf("expect the start, got %s", id)
+	}
+
+	for _, id := range []string{"0", "$", "1-1"} {
+		if err := validSeekId(id); err != nil {
+			t.Errorf("expect %s valid: %s", id, err)
+		}
+	}
+
+	if err := validSeekId("last"); err == nil {
+		t.Error("expect an invalid id")
+	}
+}
+
+func TestGroupSeek(t *testing.T) {
+	topic := "rsq:seek_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	c.Del(ctx, topic)
+
+	p := NewProducer(topic, 100, c, l, WithAvailabilityCheck(false))
+	p.Start()
+	defer p.Stop()
+
+	first, err := p.PublishSync(ctx, "1", []byte("x"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.PublishSync(ctx, "2", []byte("y"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGroup(topic, "g", "n", c, l)
+
+	if err = g.Seek(first); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := g.Fetch(ctx, 10, 0)
+	if err != nil || len(msgs) != 1 || msgs[0].Id != "2" {
+		t.Fatalf("expect the entry after the seek, got %v %v", msgs, err)
+	}
+}
+
+func TestClearSeek(t *testing.T) {
+	topic := "rsq:clear_seek_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	a := NewAdmin(c, l)
+	ctlKey := streamCtlKey(topic)
+
+	c.Del(ctx, ctlKey)
+
+	if err := a.PauseConsumer(ctx, topic, "n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SeekConsumer(ctx, topic, "n", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	cr := &ConsumerReporter{ILogger: l, client: c, topic: topic, fullName: "n"}
+	var seeked []string
+	cr.onSeek = func(id string) error {
+		seeked = append(seeked, id)
+		return nil
+	}
+
+	cr.syncControl()
+
+	ctls, err := a.Controls(ctx, topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seeked) != 1 || !cr.Paused() || ctls["n"] == nil || !ctls["n"].Paused || ctls["n"].Seek != "" {
+		t.Fatalf("expect the seek applied and cleared with the pause kept, seeked %v, control %+v", seeked, ctls["n"])
+	}
+
+	//a control changed since it was read isn't overwritten
+	if err = a.SeekConsumer(ctx, topic, "n", "1-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = clearSeek.Run(ctx, c, []string{ctlKey}, "n", "stale", "").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctls, _ = a.Controls(ctx, topic); ctls["n"] == nil || ctls["n"].Seek != "1-0" {
+		t.Fatalf("expect the new seek kept, got %+v", ctls["n"])
+	}
+}
@@ -2,6 +2,7 @@ package stream
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/wsk15046/rsq"
@@ -11,9 +12,10 @@ import (
 )
 
 type MsgNode struct {
-	Id    string
-	TagId string
-	Data  []byte
+	Id     string
+	TagId  string
+	Data   []byte
+	Header map[string]string
 }
 
 type ConsumerStat struct {
@@ -21,7 +23,11 @@ type ConsumerStat struct {
 	TagId       string
 	LastEntryId string
 	LastReadId  string
-	Latency     int64
+	Latency     int64 //ms between the last entry and the last read one, 0 when nothing is left
+	Lag         int64 //entries not delivered yet, estimated for broadcast consumers
+	Pending     int64 //entries delivered to the consumer but not acked
+	EntriesRead int64 //entries read by the group, -1 when unknown
+	Paused      bool
 	UpdateTime  time.Time
 }
 
@@ -30,21 +36,90 @@ const reportAlive = 60   //s
 
 const blockRead = 1000 //ms
 
+const readCount = 10000
+
+const batchSize = 128
+
+const handlerBatchSize = 100 //messages
+
+const handlerBatchWait = 100 //ms
+
+const pipelineDepth = 8 //entries
+
+const chanSize = 20480
+
 const latencyTolerance = 5000 //ms
 
+const lagTolerance = 8000 //entries
+
+// entries of this id were written to create the topics, they are skipped
 const msgIdCreateTopic = "createTopic"
+
+// group creating the stream of a topic, it is destroyed right away
+const createGroup = "_create"
 const tagIdAll = "$"
 
+// headers of the message at <index> are stored in the field <msgHeaderPrefix>-<index>
+const msgHeaderPrefix = "_hdr"
+
 const defaultStreamLen = 10000
 
 const keyStreamStat = "%s_stat"
 
+// consumers are controlled remotely through the hash <topic>_ctl, keyed by their full name
+const keyStreamCtl = "%s_ctl"
+
+// dead letters of a topic are moved to <topic>_dlq with their original fields and some metadata,
+// {<topic>}_dlq for a topic without hash tag so that the queue shares the slot of the topic
+const keyStreamDLQ = "%s_dlq"
+
+// the rate limit of the consumers of a topic is kept in <topic>_rate, or <topic>_rate_<tag> for a tag
+const keyStreamRate = "%s_rate"
+
+// the messages a group redelivers later wait in the zset <topic>_retry_<group>, scored by their due time in ms
+const keyStreamRetry = "%s_retry_%s"
+
+// the messages of a topic published with a priority above 0 go to the lanes <topic>_lane_<priority>,
+// or {<topic>}_lane_<priority> for a topic without hash tag so that the lanes share the slot of the topic
+const keyStreamLane = "%s_lane_%d"
+
+// the configs of the registered topics are kept in the hash rsq_topics, keyed by topic
+const keyTopicRegistry = "rsq_topics"
+
+// the quotas of the namespaces are kept in the hash rsq_namespaces, keyed by namespace
+const keyNamespaces = "rsq_namespaces"
+
+// the producers of a namespace share the publish rate limit <namespace>:rsq_publish
+const keyNamespacePublish = "rsq_publish"
+
+// the producers of a topic elect the one pruning the stale stats with the lock <topic>_monitor
+const keyStreamMonitor = "%s_monitor"
+
+const (
+	dlqFieldOrigin = "_origin" //entry id in the topic
+	dlqFieldGroup  = "_group"
+	dlqFieldReason = "_reason"
+)
+
 const consumerPrefix = "consumer"
 const groupPrefix = "group"
 
+// appendMsg encodes node as the <index>th message of a batched stream entry
+func appendMsg(m map[string]interface{}, index int, node *MsgNode) {
+	cid := fmt.Sprintf("%s-%d-%s", node.Id, index, node.TagId)
+	m[cid] = node.Data
+
+	if len(node.Header) > 0 {
+		if b, e := json.Marshal(node.Header); e == nil {
+			m[fmt.Sprintf("%s-%d", msgHeaderPrefix, index)] = b
+		}
+	}
+}
+
 func preTreatMsgs(msgs map[string]interface{}, l rsq.ILogger) (sortMsg []*MsgNode) {
 
 	sortMsg = make([]*MsgNode, len(msgs))
+	headers := make(map[int64]map[string]string)
 
 	for rawId, msg := range msgs {
 		vals := strings.Split(rawId, "-")
@@ -53,6 +128,23 @@ func preTreatMsgs(msgs map[string]interface{}, l rsq.ILogger) (sortMsg []*MsgNod
 			continue
 		}
 
+		if sid == msgHeaderPrefix && len(vals) == 2 {
+			index, e := strconv.ParseInt(vals[1], 10, 32)
+			if e != nil {
+				l.Errorf("invalid message header [%s]", rawId)
+				continue
+			}
+
+			h := make(map[string]string)
+			if e = json.Unmarshal([]byte(fmt.Sprintf("%v", msg)), &h); e != nil {
+				l.Errorf("invalid message header [%s] %s", rawId, e)
+				continue
+			}
+
+			headers[index] = h
+			continue
+		}
+
 		if len(vals) < 3 {
 			continue
 		}
@@ -72,33 +164,100 @@ func preTreatMsgs(msgs map[string]interface{}, l rsq.ILogger) (sortMsg []*MsgNod
 		}
 	}
 
+	for index, h := range headers {
+		if index < int64(len(sortMsg)) && sortMsg[index] != nil {
+			sortMsg[index].Header = h
+		}
+	}
+
 	return sortMsg
 }
 
-func createTopic(topic string, client redis.UniversalClient) {
-	//To add a message to a queue using XADD, if the specified queue does not exist, you can create a stream.
+// createTopic creates the empty stream of topic when it doesn't exist,
+// with a group created and destroyed at once since XADD can't create a stream without an entry
+func createTopic(ctx context.Context, client redis.UniversalClient, topic string) error {
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XGroupCreateMkStream(ctx, topic, createGroup, "$")
+		pipe.XGroupDestroy(ctx, topic, createGroup)
+		return nil
+	})
 
-	node := &MsgNode{
-		Id:    msgIdCreateTopic,
-		TagId: tagIdAll,
-		Data:  []byte(time.Now().String()),
+	return err
+}
+
+func streamStatKey(topic string) string {
+	return fmt.Sprintf(keyStreamStat, topic)
+}
+
+func streamCtlKey(topic string) string {
+	return fmt.Sprintf(keyStreamCtl, topic)
+}
+
+func streamDLQKey(topic string) string {
+	return fmt.Sprintf(keyStreamDLQ, hashTagged(topic))
+}
+
+func streamRateKey(topic, tagId string) string {
+	if tagId == "" {
+		return fmt.Sprintf(keyStreamRate, topic)
 	}
 
-	cid := fmt.Sprintf("%s-%d-%s", node.Id, 0, node.TagId)
-	m := make(map[string]interface{})
-	m[cid] = node.Data
+	return fmt.Sprintf(keyStreamRate, topic) + "_" + tagId
+}
+
+func streamRetryKey(topic, group string) string {
+	return fmt.Sprintf(keyStreamRetry, topic, group)
+}
 
-	ctx := context.Background()
+// LaneTopic returns the stream of the lane priority of topic, the topic itself for priority 0
+func LaneTopic(topic string, priority int) string {
+	if priority <= 0 {
+		return topic
+	}
 
-	_, _ = client.XAdd(ctx, &redis.XAddArgs{
-		Stream: topic,
-		ID:     "",
-		MaxLen: defaultStreamLen,
-		Approx: true,
-		Values: m,
-	}).Result()
+	return fmt.Sprintf(keyStreamLane, hashTagged(topic), priority)
 }
 
-func streamStatKey(topic string) string {
-	return fmt.Sprintf(keyStreamStat, topic)
+// hashTagged returns the prefix of the keys of topic hashed to its slot, the topic between braces when it has no hash tag
+func hashTagged(topic string) string {
+	if hasHashTag(topic) {
+		return topic
+	}
+
+	return "{" + topic + "}"
+}
+
+// hasHashTag tells whether a cluster hashes key by a part of it between braces
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	return end > 0
+}
+
+// derivedStream tells whether key is the dead letter queue or a lane of a topic rather than a topic
+func derivedStream(key string) bool {
+	if strings.HasSuffix(key, "_dlq") {
+		return hasHashTag(strings.TrimSuffix(key, "_dlq"))
+	}
+
+	i := strings.LastIndex(key, "_lane_")
+	if i < 0 || !hasHashTag(key[:i]) {
+		return false
+	}
+
+	_, err := strconv.Atoi(key[i+len("_lane_"):])
+	return err == nil
+}
+
+// streamLanesPattern matches the lanes of topic and their derived keys
+func streamLanesPattern(topic string) string {
+	return hashTagged(topic) + "_lane_*"
+}
+
+func streamMonitorKey(topic string) string {
+	return fmt.Sprintf(keyStreamMonitor, topic)
 }
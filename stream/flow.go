@@ -0,0 +1,193 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FullPolicy tells what a publish does when the buffer of the producer is full
+type FullPolicy int
+
+const (
+	// FullBlock waits for room in the buffer until the context of the publish is done
+	FullBlock FullPolicy = iota
+	// FullError fails the publish with kerror.QueueFullError
+	FullError
+	// FullDropOldest drops the oldest buffered message, a producer without buffer blocks instead
+	FullDropOldest
+)
+
+// flowStats counts the events of the flow control of a producer
+type flowStats struct {
+	published atomic.Int64
+	failed    atomic.Int64
+	full      atomic.Int64
+	dropped   atomic.Int64
+	rejected  atomic.Int64
+	throttled atomic.Int64
+}
+
+// localLimiter is a token bucket of the process, it protects redis without calling it
+type localLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+// newLocalLimiter allows a second of publishes at once when burst isn't set, at least one
+func newLocalLimiter(rate float64, burst int) *localLimiter {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, rate)
+	}
+
+	return &localLimiter{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// reserve takes a token and returns the delay before it is available
+func (l *localLimiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved by a publish which didn't wait for it
+func (l *localLimiter) cancel() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens++
+}
+
+// throttle waits for the publish rate limits, the one shared through redis lets the publish go when redis fails
+func (p *producer) throttle(ctx context.Context) error {
+	if err := p.throttleLocal(ctx); err != nil {
+		return err
+	}
+
+	if p.opts.publishLimiter == nil {
+		return nil
+	}
+
+	ok, _, err := p.opts.publishLimiter.Allow(p.opts.publishLimitKey, 1)
+	if err != nil {
+		p.Errorf("publish rate limit failed topic: %s, err: %s", p.topic, err)
+		return nil
+	}
+
+	if ok {
+		return nil
+	}
+
+	p.flow.throttled.Add(1)
+
+	if err = p.opts.publishLimiter.Wait(ctx, p.opts.publishLimitKey, 1); err != nil {
+		if ctx.Err() != nil {
+			p.flow.rejected.Add(1)
+			return kerror.RateLimitedError.Msgf("rate limited, topic: %s, err: %s", p.topic, ctx.Err())
+		}
+
+		p.Errorf("publish rate limit failed topic: %s, err: %s", p.topic, err)
+	}
+
+	return nil
+}
+
+func (p *producer) throttleLocal(ctx context.Context) error {
+	if p.limiter == nil {
+		return nil
+	}
+
+	wait := p.limiter.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	p.flow.throttled.Add(1)
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		p.limiter.cancel()
+		p.flow.rejected.Add(1)
+		return kerror.RateLimitedError.Msgf("rate limited, topic: %s, err: %s", p.topic, ctx.Err())
+	}
+}
+
+// enqueue buffers node for the sender, applying the full policy when the buffer is full
+func (p *producer) enqueue(ctx context.Context, node *MsgNode) error {
+	select {
+	case p.sendChan <- node:
+		return nil
+	default:
+	}
+
+	p.flow.full.Add(1)
+
+	switch p.opts.fullPolicy {
+	case FullError:
+		p.flow.rejected.Add(1)
+		return kerror.QueueFullError.Msgf("topic: %s", p.topic)
+
+	case FullDropOldest:
+		//nothing to drop without buffer, the publish waits for the sender
+		for cap(p.sendChan) > 0 {
+			select {
+			case <-p.sendChan:
+				p.flow.dropped.Add(1)
+			default:
+			}
+
+			select {
+			case p.sendChan <- node:
+				return nil
+			default:
+			}
+		}
+	}
+
+	select {
+	case p.sendChan <- node:
+		return nil
+	case <-ctx.Done():
+		p.flow.rejected.Add(1)
+		return kerror.QueueFullError.Msgf("topic: %s, err: %s", p.topic, ctx.Err())
+	}
+}
+
+func (p *producer) Stats() rsq.ProducerStats {
+	return rsq.ProducerStats{
+		Buffered:  len(p.sendChan),
+		Capacity:  cap(p.sendChan),
+		Published: p.flow.published.Load(),
+		Failed:    p.flow.failed.Load(),
+		Full:      p.flow.full.Load(),
+		Dropped:   p.flow.dropped.Load(),
+		Rejected:  p.flow.rejected.Load(),
+		Throttled: p.flow.throttled.Load(),
+	}
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/redisop"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+	"time"
+)
+
+func TestFullPolicy(t *testing.T) {
+	for _, policy := range []FullPolicy{FullBlock, FullError, FullDropOldest} {
+		p := &producer{topic: "rsq:flow_test", sendChan: make(chan *MsgNode, 2), opts: newOptions(WithFullPolicy(policy))}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		var errs []error
+		for _, id := range []string{"1", "2", "3"} {
+			errs = append(errs, p.enqueue(ctx, &MsgNode{Id: id}))
+		}
+		cancel()
+
+		stats := p.Stats()
+		if stats.Full != 1 || stats.Buffered != 2 || stats.Capacity != 2 {
+			t.Errorf("policy %d unexpected stats %+v", policy, stats)
+		}
+
+		switch policy {
+		case FullBlock, FullError:
+			ke, ok := errs[2].(*kerror.KError)
+			if !ok || ke.Code() != kerror.QueueFullError.Code() || stats.Rejected != 1 {
+				t.Errorf("policy %d expect a full error, got %v %+v", policy, errs[2], stats)
+			}
+		case FullDropOldest:
+			if errs[2] != nil || stats.Dropped != 1 || (<-p.sendChan).Id != "2" {
+				t.Errorf("expect the oldest dropped, got %v %+v", errs[2], stats)
+			}
+		}
+	}
+
+	//without buffer there is nothing to drop, the publish blocks
+	p := &producer{topic: "rsq:flow_test", sendChan: make(chan *MsgNode), opts: newOptions(WithFullPolicy(FullDropOldest))}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := p.enqueue(ctx, &MsgNode{Id: "1"})
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.QueueFullError.Code() || p.Stats().Dropped != 0 {
+		t.Errorf("expect a full error, got %v %+v", err, p.Stats())
+	}
+}
+
+func TestPublishRate(t *testing.T) {
+	p := &producer{topic: "rsq:flow_test", opts: newOptions(), limiter: newLocalLimiter(20, 2)}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := p.throttle(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2 publishes of the burst, then one every 50ms
+	if d := time.Since(start); d < 90*time.Millisecond || d > 300*time.Millisecond {
+		t.Errorf("unexpected throttle duration %s", d)
+	}
+
+	if n := p.Stats().Throttled; n != 2 {
+		t.Errorf("expect 2 throttled publishes, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.throttle(ctx)
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.RateLimitedError.Code() {
+		t.Errorf("expect a canceled publish to be rate limited, got %v", err)
+	}
+
+	//a second of publishes at once by default, at least one
+	if b := newLocalLimiter(20, 0).burst; b != 20 {
+		t.Errorf("expect a burst of 20, got %f", b)
+	}
+
+	if b := newLocalLimiter(0.5, 0).burst; b != 1 {
+		t.Errorf("expect a burst of 1, got %f", b)
+	}
+}
+
+func TestPublishLimit(t *testing.T) {
+	c, l := test.Dependency()
+	key := "rsq:flow_test_limit"
+	c.Del(context.Background(), key)
+
+	rl := redisop.NewTokenBucket(c, l, 1, 1)
+	p := &producer{ILogger: l, topic: "rsq:flow_test", opts: newOptions(WithPublishLimit(rl, key))}
+
+	if err := p.throttle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	//the bucket is empty for a second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := p.throttle(ctx)
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.RateLimitedError.Code() || p.Stats().Rejected != 1 {
+		t.Errorf("expect the publish rate limited, got %v", err)
+	}
+
+	if n := p.Stats().Throttled; n != 1 {
+		t.Errorf("expect 1 throttled publish, got %d", n)
+	}
+}
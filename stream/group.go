@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/wsk15046/rsq"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 	"time"
 )
 
@@ -16,17 +18,26 @@ type Group struct {
 	name    string
 	tagId   string
 	client  redis.UniversalClient
-	handler rsq.ConsumerHandler
+	handler rsq.MessageHandler
 	quit    chan bool
+	opts    *options
+	tracer  trace.Tracer
 
-	cr *ConsumerReporter
+	batchHandler rsq.BatchHandler
+	batch        *handlerBatch
+
+	cr         *ConsumerReporter
+	reportOnce sync.Once
+	stopOnce   sync.Once
 }
 
 //In the case of the same topic
 //if the group name is the same, messages will be randomly distributed to consumers within the same group.
 //If the group name is different, messages will be broadcasted to different groups.
 
-func NewGroup(topic, group, name string, cl redis.UniversalClient, l rsq.ILogger) *Group {
+func NewGroup(topic, group, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Group {
+
+	o := newOptions(opts...)
 
 	g := &Group{
 		ILogger: l,
@@ -37,11 +48,14 @@ func NewGroup(topic, group, name string, cl redis.UniversalClient, l rsq.ILogger
 		tagId:  group,
 		client: cl,
 		quit:   make(chan bool),
+		opts:   o,
+		tracer: o.tracer(),
+		batch:  newHandlerBatch(o),
 	}
 
-	g.cr = NewConsumerReport(topic, g.tagId, g.FullName(), cl, l)
-
-	createTopic(topic, cl)
+	g.cr = NewGroupReport(topic, group, name, g.FullName(), cl, l)
+	g.cr.interval = o.reportInterval
+	g.cr.onSeek = g.Seek
 
 	if _, e := g.xGroupCreate(); e != nil {
 		l.Warnf("create group failed [ %s ]", e.Error())
@@ -51,7 +65,19 @@ func NewGroup(topic, group, name string, cl redis.UniversalClient, l rsq.ILogger
 }
 
 func (g *Group) SetHandler(h rsq.ConsumerHandler) {
+	g.handler = wrapHandler(h)
+	g.batchHandler = nil
+}
+
+func (g *Group) SetMessageHandler(h rsq.MessageHandler) {
 	g.handler = h
+	g.batchHandler = nil
+}
+
+// SetBatchHandler hands the messages to h in batches, see WithHandlerBatch.
+// An entry is acked once all its messages are handled, an entry with a failed message stays pending until it is claimed.
+func (g *Group) SetBatchHandler(h rsq.BatchHandler) {
+	g.batchHandler = h
 }
 
 func (g *Group) TagId() string {
@@ -66,18 +92,44 @@ func (g *Group) Topic() string {
 	return g.topic
 }
 
+func (g *Group) Group() string {
+	return g.group
+}
+
 func (g *Group) Subscribe() {
-	g.cr.StartReport()
+	g.reportOnce.Do(g.cr.StartReport)
 	go g.xReadGroup()
 }
 
+// Stop stops the read loop and the reports, it can be called without Subscribe
 func (g *Group) Stop() {
-	g.quit <- true
+	g.stopOnce.Do(func() {
+		close(g.quit)
+		g.cr.StopReport()
+	})
+}
+
+func (g *Group) Pause() {
+	g.cr.local.Store(true)
+}
+
+func (g *Group) Resume() {
+	g.cr.local.Store(false)
+}
+
+// Seek moves the last delivered id of the group with XGROUP SETID, the entries pending for its consumers stay pending
+func (g *Group) Seek(id string) error {
+	if err := validSeekId(id); err != nil {
+		return err
+	}
+
+	return g.client.XGroupSetID(context.Background(), g.topic, g.group, id).Err()
 }
 
+// xGroupCreate creates the group, and the stream of the topic when it doesn't exist
 func (g *Group) xGroupCreate() (ret string, err error) {
 	var ctx = context.Background()
-	return g.client.XGroupCreate(ctx, g.topic, g.group, "0").Result()
+	return g.client.XGroupCreateMkStream(ctx, g.topic, g.group, "0").Result()
 }
 
 // During startup, start by checking and reading messages from the beginning,
@@ -86,88 +138,56 @@ func (g *Group) xGroupCreate() (ret string, err error) {
 func (g *Group) xReadGroup() {
 
 	ctx := context.Background()
-	lastId := ">" // consume from lastdeliveredID
-	checkBacklog := true
-
-	// in millisecond
-	count := int64(0)
+	lastId := "0" // the entries delivered to the consumer and not acked, then ">" for the new ones
 
 	for {
 		select {
 		case <-g.quit:
+			if len(g.batch.msgs) > 0 {
+				g.flushBatch(ctx)
+			}
 			g.Errorf("consumer quit %s", g.topic)
 			return
 		default:
+			if g.batch.due() {
+				g.flushBatch(ctx)
+			}
 
-			var id string
-			if checkBacklog {
-				id = lastId
-			} else {
-				id = ">"
+			if g.cr.Paused() {
+				time.Sleep(g.cr.interval)
+				continue
+			}
+
+			g.redeliver(ctx)
+
+			block := g.batch.block(g.opts.block)
+			if lastId != ">" {
+				block = -1
 			}
 
 			data, errRead := g.client.XReadGroup(ctx, &redis.XReadGroupArgs{
 				Group:    g.group,
 				Consumer: g.name,
-				Streams:  []string{g.topic, id},
-				Count:    10000,
-				Block:    0,
+				Streams:  []string{g.topic, lastId},
+				Count:    g.opts.readCount,
+				Block:    block,
 				NoAck:    false,
 			}).Result()
 
 			if data != nil && len(data) > 0 {
 
-				// Indicate that the previously unacknowledged messages have been processed,
-				// and begin handling new messages. Change lastId to ">".
-				if len(data[0].Messages) == 0 {
-					checkBacklog = false
-				}
-
-				var ids []string
-
 				for _, result := range data {
-					for _, message := range result.Messages {
-						if g.handler != nil {
-
-							sortMsg := preTreatMsgs(message.Values, g.ILogger)
-
-							for _, node := range sortMsg {
-								if node == nil {
-									continue
-								}
-
-								pid := node.Id
-								pData := node.Data
-								tagId := node.TagId
-
-								// not mine nor broadcast
-								if tagId != tagIdAll && g.tagId != tagIdAll && g.tagId != tagId {
-									continue
-								}
-
-								//Closure trap, must pass new variables into the closure function
-								if pid != msgIdCreateTopic {
-									g.handler(pid, pData, g)
-								}
-							}
-
-							ids = append(ids, message.ID)
-							id = message.ID
-							count += int64(len(sortMsg))
-						}
-					}
+					g.consume(ctx, lastId, result.Messages)
 				}
 
-				if l := len(ids); l > 0 {
-
-					g.cr.Update(ids[l-1], count)
-
-					count = 0
-
-					if _, errAck := g.xAck(ctx, ids...); errAck != nil {
-						g.Errorf("MQGroup:xReadGroup:err_ack: %s, topic: %s, group: %s, name: %s",
-							errAck, g.topic, g.group, g.name)
-						time.Sleep(time.Second)
+				// Indicate that the previously unacknowledged messages have been processed,
+				// and begin handling new messages. Change lastId to ">".
+				// The backlog is read past the entries which failed again, they are left to the redelivery.
+				if lastId != ">" {
+					if messages := data[0].Messages; len(messages) == 0 {
+						lastId = ">"
+					} else {
+						lastId = messages[len(messages)-1].ID
 					}
 				}
 
@@ -176,10 +196,191 @@ func (g *Group) xReadGroup() {
 					g.Errorf("MQGroup:xReadGroup:err_read: %s, topic: %s, group: %s, name: %s",
 						errRead, g.topic, g.group, g.name)
 					time.Sleep(time.Second)
+				} else {
+					lastId = ">"
+				}
+			}
+		}
+	}
+}
+
+// consume handles the entries read from the topic and acks them, it returns the id of the last handled one
+func (g *Group) consume(ctx context.Context, readId string, messages []redis.XMessage) string {
+	var ids []string
+	count := int64(0)
+
+	deliveries := g.deliveryCounts(ctx, readId, messages)
+
+	for _, message := range messages {
+		if g.deadLettered(ctx, message.ID, deliveries[message.ID]) {
+			continue
+		}
+
+		if g.batchHandler != nil {
+			g.batch.add(message.ID, g.decode(message, deliveries[message.ID]), func() { g.flushBatch(ctx) })
+			continue
+		}
+
+		if g.handler != nil {
+
+			msgs := g.decode(message, deliveries[message.ID])
+
+			pending := false
+			for _, msg := range msgs {
+				g.opts.throttle(g.topic, g.tagId, g.ILogger)
+				if g.handle(ctx, msg) != nil {
+					pending = true
 				}
 			}
+
+			if !pending {
+				ids = append(ids, message.ID)
+			}
+			count += int64(len(msgs))
 		}
 	}
+
+	return g.ack(ctx, ids, count)
+}
+
+// flushBatch hands the batch to the batch handler and acks the entries whose messages are all handled
+func (g *Group) flushBatch(ctx context.Context) {
+	msgs := g.batch.msgs
+	for range msgs {
+		g.opts.throttle(g.topic, g.tagId, g.ILogger)
+	}
+
+	errs := handleBatch(g.tracer, g.batchHandler, msgs, g.group, g, g.ILogger)
+
+	if errs != nil && g.opts.retry.enabled() {
+		errs = g.retryBatch(msgs, errs)
+		for i, err := range errs {
+			if err != nil {
+				errs[i] = g.retryLater(ctx, msgs[i], err, 0, "")
+			}
+		}
+	}
+
+	g.ack(ctx, g.batch.reset(errs), int64(len(msgs)))
+}
+
+// handle calls the handler, with a retry policy a failed message is retried, queued for a redelivery or dead lettered,
+// without one it stays pending until it is claimed. It returns an error when the message must stay pending.
+func (g *Group) handle(ctx context.Context, msg *rsq.Message) error {
+	err := handleMessage(g.tracer, g.handler, msg, g.group, g, g.ILogger)
+	if err == nil || !g.opts.retry.enabled() {
+		return err
+	}
+
+	if err = g.retry(msg, err); err == nil {
+		return nil
+	}
+
+	if err = g.retryLater(ctx, msg, err, 0, ""); err != nil {
+		g.Errorf("MQGroup:retryLater: %s, topic: %s, group: %s, id: %s", err, g.topic, g.group, msg.Id)
+	}
+
+	return err
+}
+
+// ack acks the handled entries, it returns the id of the last one
+func (g *Group) ack(ctx context.Context, ids []string, count int64) string {
+	l := len(ids)
+	if l == 0 {
+		return ""
+	}
+
+	g.cr.Update(ids[l-1], count)
+
+	if _, errAck := g.xAck(ctx, ids...); errAck != nil {
+		g.Errorf("MQGroup:xReadGroup:err_ack: %s, topic: %s, group: %s, name: %s",
+			errAck, g.topic, g.group, g.name)
+		time.Sleep(time.Second)
+	}
+
+	return ids[l-1]
+}
+
+// decode returns the messages of an entry sent to the group
+func (g *Group) decode(message redis.XMessage, deliveryCount int64) []*rsq.Message {
+	var msgs []*rsq.Message
+
+	for _, node := range preTreatMsgs(message.Values, g.ILogger) {
+		if node == nil || node.Id == msgIdCreateTopic {
+			continue
+		}
+
+		// not mine nor broadcast
+		if node.TagId != tagIdAll && g.tagId != tagIdAll && g.tagId != node.TagId {
+			continue
+		}
+
+		msgs = append(msgs, &rsq.Message{
+			Id:            node.Id,
+			TagId:         node.TagId,
+			Data:          node.Data,
+			Header:        node.Header,
+			Topic:         g.topic,
+			EntryId:       message.ID,
+			DeliveryCount: deliveryCount,
+			Attempt:       1,
+		})
+	}
+
+	//entries of the single entry mode carry one message
+	if len(msgs) > 1 {
+		for _, msg := range msgs {
+			msg.Batched = true
+		}
+	}
+
+	return msgs
+}
+
+// deliveryCounts returns the delivery count of each entry, entries read with ">" are delivered for the first time
+func (g *Group) deliveryCounts(ctx context.Context, readId string, msgs []redis.XMessage) map[string]int64 {
+	m := make(map[string]int64, len(msgs))
+	for _, message := range msgs {
+		m[message.ID] = 1
+	}
+
+	if readId == ">" || len(msgs) == 0 {
+		return m
+	}
+
+	pending, e := g.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   g.topic,
+		Group:    g.group,
+		Start:    msgs[0].ID,
+		End:      msgs[len(msgs)-1].ID,
+		Count:    int64(len(msgs)),
+		Consumer: g.name,
+	}).Result()
+	if e != nil && e != redis.Nil {
+		g.Errorf("MQGroup:xPendingExt: %s, topic: %s, group: %s, name: %s", e, g.topic, g.group, g.name)
+		return m
+	}
+
+	for _, p := range pending {
+		m[p.ID] = p.RetryCount
+	}
+
+	return m
+}
+
+// deadLettered moves an entry delivered too many times to the dead letter queue
+func (g *Group) deadLettered(ctx context.Context, entryId string, deliveryCount int64) bool {
+	if g.opts.maxDeliveries <= 0 || deliveryCount <= g.opts.maxDeliveries {
+		return false
+	}
+
+	reason := fmt.Sprintf("delivered %d times", deliveryCount-1)
+	if _, err := g.DeadLetter(ctx, reason, entryId); err != nil {
+		g.Errorf("MQGroup:deadLetter: %s, topic: %s, group: %s, entry: %s", err, g.topic, g.group, entryId)
+		return false
+	}
+
+	return true
 }
 
 func (g *Group) xAck(ctx context.Context, ids ...string) (ret int64, err error) {
@@ -198,14 +399,20 @@ func (g *Group) xClaim(ctx context.Context, monitoringConsumerName string, toBeC
 	return nil
 }
 
-func (g *Group) xPending(ctx context.Context, cnt int64) (*redis.XPending, error) {
-	return g.client.XPending(ctx, g.topic, g.group).Result()
+// Pending returns the count of entries delivered to the consumer but not acked
+func (g *Group) Pending(ctx context.Context) (int64, error) {
+	pending, err := pendingByConsumer(ctx, g.client, g.topic, g.group)
+	if err != nil {
+		return 0, err
+	}
+
+	return pending[g.name], nil
 }
 
-func (g *Group) xInfoGroup(ctx context.Context) (infos []redis.XInfoGroup, err error) {
-	return g.client.XInfoGroups(ctx, g.topic).Result()
+func (g *Group) xInfoGroup(ctx context.Context) (infos []*GroupInfo, err error) {
+	return xInfoGroups(ctx, g.client, g.topic)
 }
 
-func (g *Group) xInfoStream(ctx context.Context) (info *redis.XInfoStream, err error) {
-	return g.client.XInfoStream(ctx, g.topic).Result()
+func (g *Group) xInfoStream(ctx context.Context) (info *StreamInfo, err error) {
+	return xInfoStream(ctx, g.client, g.topic)
 }
@@ -0,0 +1,144 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pendingEntry publishes an entry and delivers it reads times to the consumer n of the group g without acking it
+func pendingEntry(t *testing.T, topic string, reads int) string {
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	c.Del(ctx, topic, streamDLQKey(topic))
+	c.XGroupCreateMkStream(ctx, topic, "g", "0")
+
+	p := NewProducer(topic, 100, c, l, WithAvailabilityCheck(false))
+	p.Start()
+	defer p.Stop()
+
+	id, err := p.PublishSync(ctx, "1", []byte("x"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < reads; i++ {
+		start := "0"
+		if i == 0 {
+			start = ">"
+		}
+		c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "n", Streams: []string{topic, start}, Count: 1, Block: -1})
+	}
+
+	return id
+}
+
+func TestGroupMaxDeliveries(t *testing.T) {
+	topic := "rsq:group_max_deliveries_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	id := pendingEntry(t, topic, 3)
+
+	var handled int32
+	g := NewGroup(topic, "g", "n", c, l, WithMaxDeliveries(2), WithBlock(100*time.Millisecond))
+	g.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	g.Subscribe()
+	defer g.Stop()
+
+	time.Sleep(time.Second)
+
+	if n := atomic.LoadInt32(&handled); n != 0 {
+		t.Errorf("expect the entry not handled, handled %d times", n)
+	}
+
+	letters, err := NewAdmin(c, l).DeadLetters(ctx, topic, "", 10)
+	if err != nil || len(letters) != 1 || letters[0].Origin != id {
+		t.Fatalf("expect the entry in %s, got %v %v", streamDLQKey(topic), letters, err)
+	}
+
+	if n := c.XPending(ctx, topic, "g").Val().Count; n != 0 {
+		t.Errorf("expect the entry acked, %d pending", n)
+	}
+}
+
+func TestGroupHandlerError(t *testing.T) {
+	topic := "rsq:group_handler_error_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	pendingEntry(t, topic, 0)
+
+	var handled int32
+	g := NewGroup(topic, "g", "n", c, l, WithBlock(100*time.Millisecond))
+	g.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		atomic.AddInt32(&handled, 1)
+		return errors.New("failed")
+	})
+	g.Subscribe()
+	defer g.Stop()
+
+	time.Sleep(time.Second)
+
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("expect the entry handled once, handled %d times", n)
+	}
+
+	//without a retry policy the entry is left pending
+	if n := c.XPending(ctx, topic, "g").Val().Count; n != 1 {
+		t.Errorf("expect the entry pending, %d pending", n)
+	}
+}
+
+func TestGroupDeliveryCount(t *testing.T) {
+	topic := "rsq:group_delivery_count_test"
+	c, l := test.Dependency()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	//delivered twice before the restart, the third delivery is read from the backlog
+	pendingEntry(t, topic, 2)
+
+	var count int64
+	g := NewGroup(topic, "g", "n", c, l, WithBlock(100*time.Millisecond), WithTracerProvider(tp))
+	g.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		atomic.StoreInt64(&count, msg.DeliveryCount)
+		return nil
+	})
+	g.Subscribe()
+	defer g.Stop()
+
+	time.Sleep(time.Second)
+
+	if n := atomic.LoadInt64(&count); n != 3 {
+		t.Fatalf("expect the message delivered 3 times, got %d", n)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].SpanKind != trace.SpanKindConsumer {
+		t.Fatalf("expect a process span, got %d", len(spans))
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[attrDeliveryCount].AsInt64() != 3 {
+		t.Errorf("unexpected attributes %v", spans[0].Attributes)
+	}
+}
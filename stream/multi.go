@@ -0,0 +1,442 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscription is a topic read by a MultiConsumer
+type subscription struct {
+	consumer  rsq.IMQConsumer
+	c         *consumer //nil for groups
+	cr        *ConsumerReporter
+	id        string //id the next read of the topic starts after
+	consume   func(ctx context.Context, messages []redis.XMessage) string
+	batch     *handlerBatch
+	flush     func(ctx context.Context)
+	redeliver func(ctx context.Context) //nil for broadcast consumers
+	weight    int                       //share of the reads of a priority lane
+}
+
+// MultiConsumer reads several topics in a single blocking read loop, as a broadcast consumer or as a consumer of a group.
+// Each topic keeps its own consumer, its stats, controls and options, the handlers are called with it.
+// In a cluster the topics must hash to the same slot, with a hash tag like {orders}.created and {orders}.paid.
+type MultiConsumer struct {
+	rsq.ILogger
+
+	group  string //empty for broadcast consumers
+	name   string
+	lanes  bool //the topics are the lanes of a priority consumer, see readLanes
+	client redis.UniversalClient
+	opts   *options
+	subs   []*subscription
+	quit   chan bool
+	once   sync.Once
+}
+
+// NewMultiConsumer creates a broadcast consumer of topics, see NewConsumer
+func NewMultiConsumer(topics []string, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *MultiConsumer {
+	m := newMultiConsumer("", name, cl, l, opts...)
+	for _, topic := range topics {
+		m.add(topic, opts...)
+	}
+
+	return m
+}
+
+// NewMultiGroup creates a consumer of the group on topics, see NewGroup
+func NewMultiGroup(topics []string, group, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *MultiConsumer {
+	m := newMultiConsumer(group, name, cl, l, opts...)
+	for _, topic := range topics {
+		m.add(topic, opts...)
+	}
+
+	return m
+}
+
+func newMultiConsumer(group, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *MultiConsumer {
+	return &MultiConsumer{
+		ILogger: l,
+
+		group:  group,
+		name:   name,
+		client: cl,
+		opts:   newOptions(opts...),
+		quit:   make(chan bool),
+	}
+}
+
+// add subscribes to topic, opts are the options of its consumer
+func (m *MultiConsumer) add(topic string, opts ...Option) {
+	if m.group == "" {
+		c := newConsumer(topic, m.name, m.client, m.ILogger, opts...)
+		m.subs = append(m.subs, &subscription{consumer: c, c: c, cr: c.cr, id: "$", consume: c.consume, batch: c.batch, flush: c.flushBatch})
+		return
+	}
+
+	//a group first reads the entries delivered to the consumer and not acked, from "0", then the new ones with ">"
+	g := NewGroup(topic, m.group, m.name, m.client, m.ILogger, opts...)
+	s := &subscription{
+		consumer:  g,
+		cr:        g.cr,
+		id:        "0",
+		batch:     g.batch,
+		flush:     g.flushBatch,
+		redeliver: g.redeliver,
+	}
+	s.consume = func(ctx context.Context, messages []redis.XMessage) string {
+		return g.consume(ctx, s.id, messages)
+	}
+	m.subs = append(m.subs, s)
+}
+
+// Topic returns the topics joined by commas
+func (m *MultiConsumer) Topic() string {
+	return strings.Join(m.Topics(), ",")
+}
+
+func (m *MultiConsumer) Topics() []string {
+	topics := make([]string, 0, len(m.subs))
+	for _, s := range m.subs {
+		topics = append(topics, s.consumer.Topic())
+	}
+
+	return topics
+}
+
+func (m *MultiConsumer) FullName() string {
+	if m.group == "" {
+		return fmt.Sprintf("%s@%s", consumerPrefix, m.name)
+	}
+
+	return fmt.Sprintf("%s@%s@%s", groupPrefix, m.group, m.name)
+}
+
+func (m *MultiConsumer) TagId() string {
+	if m.group == "" {
+		return m.name
+	}
+
+	return m.group
+}
+
+// Consumer returns the consumer of a topic, nil when it isn't subscribed
+func (m *MultiConsumer) Consumer(topic string) rsq.IMQConsumer {
+	for _, s := range m.subs {
+		if s.consumer.Topic() == topic {
+			return s.consumer
+		}
+	}
+
+	return nil
+}
+
+// SetHandler sets the handler of every topic, it tells the topics apart by the consumer it receives
+func (m *MultiConsumer) SetHandler(h rsq.ConsumerHandler) {
+	for _, s := range m.subs {
+		s.consumer.SetHandler(h)
+	}
+}
+
+// SetMessageHandler sets the handler of every topic, it tells the topics apart by Message.Topic
+func (m *MultiConsumer) SetMessageHandler(h rsq.MessageHandler) {
+	for _, s := range m.subs {
+		s.consumer.SetMessageHandler(h)
+	}
+}
+
+// SetBatchHandler sets the batch handler of every topic, a batch holds the messages of a single topic
+func (m *MultiConsumer) SetBatchHandler(h rsq.BatchHandler) {
+	for _, s := range m.subs {
+		s.consumer.SetBatchHandler(h)
+	}
+}
+
+// Handle sets the handler of a topic, it overrides the one set for every topic
+func (m *MultiConsumer) Handle(topic string, h rsq.MessageHandler) {
+	if c := m.Consumer(topic); c != nil {
+		c.SetMessageHandler(h)
+		return
+	}
+
+	m.Warnf("handler of topic %s not subscribed by %s", topic, m.FullName())
+}
+
+func (m *MultiConsumer) Subscribe() {
+	ctx := context.Background()
+
+	for _, s := range m.subs {
+		s.cr.StartReport()
+
+		//a topic without new entries keeps its id while the others are read, "$" would skip the entries added in between
+		if s.id == "$" {
+			s.id = m.lastId(ctx, s.consumer.Topic())
+		}
+	}
+
+	go m.read()
+}
+
+func (m *MultiConsumer) Stop() {
+	m.once.Do(func() {
+		close(m.quit)
+		for _, s := range m.subs {
+			s.cr.StopReport()
+		}
+	})
+}
+
+// Pause pauses every topic, the consumer of a topic pauses it alone
+func (m *MultiConsumer) Pause() {
+	for _, s := range m.subs {
+		s.consumer.Pause()
+	}
+}
+
+func (m *MultiConsumer) Resume() {
+	for _, s := range m.subs {
+		s.consumer.Resume()
+	}
+}
+
+// Seek moves every topic to id, SeekId gives the id of a time valid for all of them
+func (m *MultiConsumer) Seek(id string) error {
+	for _, s := range m.subs {
+		if err := s.consumer.Seek(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MultiConsumer) read() {
+	ctx := context.Background()
+
+	for {
+		select {
+		case <-m.quit:
+			for _, s := range m.subs {
+				if len(s.batch.msgs) > 0 {
+					s.flush(ctx)
+				}
+			}
+			m.Infof("consumer quit %s", m.Topic())
+			return
+		default:
+		}
+
+		block := m.opts.block
+		for _, s := range m.subs {
+			if s.batch.due() {
+				s.flush(ctx)
+			}
+			block = s.batch.block(block)
+		}
+
+		//paused topics are left out of the read
+		subs := make([]*subscription, 0, len(m.subs))
+		for _, s := range m.subs {
+			if s.cr.Paused() {
+				continue
+			}
+
+			if s.c != nil {
+				if id, ok := s.c.takeSeek(); ok {
+					if id == "$" {
+						id = m.lastId(ctx, s.consumer.Topic())
+					}
+					s.id = id
+				}
+			}
+
+			if s.redeliver != nil {
+				s.redeliver(ctx)
+			}
+			subs = append(subs, s)
+		}
+
+		if len(subs) == 0 {
+			time.Sleep(m.opts.reportInterval)
+			continue
+		}
+
+		var data []redis.XStream
+		var err error
+		if m.lanes {
+			data, err = m.readLanes(ctx, subs, block)
+		} else {
+			data, err = m.xRead(ctx, streams(subs), m.opts.readCount, block)
+		}
+
+		if err != nil && err != redis.Nil {
+			m.Errorf("MultiConsumer:read: %s, topics: %s, name: %s", err, m.Topic(), m.FullName())
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, result := range data {
+			for _, s := range subs {
+				if s.consumer.Topic() != result.Stream {
+					continue
+				}
+
+				lastId := s.consume(ctx, result.Messages)
+				if m.group == "" {
+					if lastId != "" {
+						s.id = lastId
+					}
+					continue
+				}
+
+				//the backlog is read past the entries which failed again, they are left to the redelivery
+				if s.id != ">" {
+					if len(result.Messages) == 0 {
+						s.id = ">"
+					} else {
+						s.id = result.Messages[len(result.Messages)-1].ID
+					}
+				}
+			}
+		}
+	}
+}
+
+// lastId returns the id of the last entry of topic, "$" when it can't be read
+func (m *MultiConsumer) lastId(ctx context.Context, topic string) string {
+	id, err := lastEntryId(ctx, m.client, topic)
+	if err != nil {
+		m.Errorf("MultiConsumer:lastId: %s, topic: %s", err, topic)
+		return "$"
+	}
+
+	return id
+}
+
+// lastEntryId returns the id of the last entry of topic, "0-0" when it is empty
+func lastEntryId(ctx context.Context, client redis.UniversalClient, topic string) (string, error) {
+	msgs, err := client.XRevRangeN(ctx, topic, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+
+	return msgs[0].ID, nil
+}
+
+// streams returns the streams argument of a read of subs
+func streams(subs []*subscription) []string {
+	streams := make([]string, 0, 2*len(subs))
+	for _, s := range subs {
+		streams = append(streams, s.consumer.Topic())
+	}
+	for _, s := range subs {
+		streams = append(streams, s.id)
+	}
+
+	return streams
+}
+
+// xRead reads streams, a negative block doesn't wait
+func (m *MultiConsumer) xRead(ctx context.Context, streams []string, count int64, block time.Duration) ([]redis.XStream, error) {
+	return m.xReadCmd(ctx, m.client, streams, count, block).Result()
+}
+
+// xReadCmd issues the read of streams on c, a client or a pipeline
+func (m *MultiConsumer) xReadCmd(ctx context.Context, c redis.Cmdable, streams []string, count int64, block time.Duration) *redis.XStreamSliceCmd {
+	if m.group == "" {
+		return c.XRead(ctx, &redis.XReadArgs{
+			Streams: streams,
+			Count:   count,
+			Block:   block,
+		})
+	}
+
+	return c.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    m.group,
+		Consumer: m.name,
+		Streams:  streams,
+		Count:    count,
+		Block:    block,
+	})
+}
+
+// Match returns the topics matching patterns, a pattern with the wildcards of path.Match is matched against
+// the registered topics, other patterns are topics registered or not
+func (r *Registry) Match(ctx context.Context, patterns ...string) ([]string, error) {
+	var registered []*TopicConfig
+	seen := make(map[string]bool)
+	var topics []string
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[\\") {
+			if !seen[pattern] {
+				seen[pattern] = true
+				topics = append(topics, pattern)
+			}
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+
+		if registered == nil {
+			var err error
+			if registered, err = r.Topics(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, cfg := range registered {
+			if ok, _ := path.Match(pattern, cfg.Name); ok && !seen[cfg.Name] {
+				seen[cfg.Name] = true
+				topics = append(topics, cfg.Name)
+			}
+		}
+	}
+
+	sort.Strings(topics)
+
+	return topics, nil
+}
+
+// NewMultiConsumer creates a broadcast consumer of the topics matching patterns, the consumer of each topic
+// applies its config, opts override them. The patterns are resolved once, topics registered later aren't read.
+func (r *Registry) NewMultiConsumer(ctx context.Context, patterns []string, name string, opts ...Option) (*MultiConsumer, error) {
+	return r.newMulti(ctx, patterns, "", name, opts...)
+}
+
+// NewMultiGroup creates a consumer of the group on the topics matching patterns, see NewMultiConsumer
+func (r *Registry) NewMultiGroup(ctx context.Context, patterns []string, group, name string, opts ...Option) (*MultiConsumer, error) {
+	return r.newMulti(ctx, patterns, group, name, opts...)
+}
+
+func (r *Registry) newMulti(ctx context.Context, patterns []string, group, name string, opts ...Option) (*MultiConsumer, error) {
+	topics, err := r.Match(ctx, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newMultiConsumer(group, name, r.client, r.ILogger, opts...)
+	for _, topic := range topics {
+		cfg, err := r.config(ctx, topic)
+		if err != nil {
+			return nil, err
+		}
+
+		m.add(topic, append(cfg.GroupOptions(group), opts...)...)
+	}
+
+	return m, nil
+}
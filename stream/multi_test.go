@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultiGroup(t *testing.T) {
+	topics := []string{"{rsq}:multi_test_a", "{rsq}:multi_test_b"}
+	c, l := test.Dependency()
+	ctx := context.Background()
+	r := NewRegistry(c, l)
+
+	for _, topic := range topics {
+		_ = r.DeleteTopic(ctx, topic)
+		if err := r.CreateTopic(ctx, &TopicConfig{Name: topic}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := r.NewMultiGroup(ctx, []string{"{rsq}:multi_test_*"}, "g", "n", WithBlock(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Topics(); len(got) != 2 {
+		t.Fatalf("expect the topics matched, got %v", got)
+	}
+
+	var mutex sync.Mutex
+	received := make(map[string]int)
+	m.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received[msg.Topic]++
+		return nil
+	})
+	m.Subscribe()
+	defer m.Stop()
+
+	for _, topic := range topics {
+		p := NewProducer(topic, 100, c, l)
+		p.Start()
+		if _, err = p.PublishSync(ctx, "1", []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+		p.Stop()
+	}
+
+	time.Sleep(time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, topic := range topics {
+		if received[topic] != 1 {
+			t.Errorf("expect a message of %s, got %v", topic, received)
+		}
+	}
+}
+
+func TestMultiGroupBacklog(t *testing.T) {
+	topics := []string{"{rsq}:multi_backlog_a", "{rsq}:multi_backlog_b"}
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	//an entry of each topic delivered to the consumer before a restart and not acked
+	for _, topic := range topics {
+		c.Del(ctx, topic)
+		c.XGroupCreateMkStream(ctx, topic, "g", "0")
+
+		p := NewProducer(topic, 100, c, l, WithAvailabilityCheck(false))
+		p.Start()
+		if _, err := p.PublishSync(ctx, "1", []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+		p.Stop()
+
+		c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "n", Streams: []string{topic, ">"}, Count: 1, Block: -1})
+	}
+
+	var mutex sync.Mutex
+	received := make(map[string]int)
+	m := NewMultiGroup(topics, "g", "n", c, l, WithBlock(100*time.Millisecond))
+	m.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received[msg.Topic]++
+		return nil
+	})
+	m.Subscribe()
+	defer m.Stop()
+
+	time.Sleep(time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, topic := range topics {
+		if received[topic] == 0 {
+			t.Errorf("expect the pending entry of %s, got %v", topic, received)
+		}
+
+		if n := c.XPending(ctx, topic, "g").Val().Count; n != 0 {
+			t.Errorf("expect the entry of %s acked, %d pending", topic, n)
+		}
+	}
+}
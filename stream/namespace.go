@@ -0,0 +1,299 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/redisop"
+	"sort"
+	"strings"
+)
+
+// Quota limits the resources of a namespace, a zero field is no limit
+type Quota struct {
+	MaxTopics   int     `json:"maxTopics,omitempty"`
+	MaxLen      int64   `json:"maxLen,omitempty"`      //caps the max length kept by the producers of each topic
+	PublishRate float64 `json:"publishRate,omitempty"` //messages per second published to all the topics of the namespace
+}
+
+// NamespaceKey returns the key of a topic, or of any key rsq creates, in the namespace ns
+func NamespaceKey(ns, key string) string {
+	if ns == "" {
+		return key
+	}
+
+	return ns + ":" + key
+}
+
+func validNamespace(ns string) error {
+	if ns == "" || strings.ContainsAny(ns, ":*?[]\\") {
+		return kerror.SystemError.Msgf("invalid namespace %q", ns)
+	}
+
+	return nil
+}
+
+// Namespace prefixes the keys of its topics with <namespace>: so that teams sharing a redis don't collide.
+// The streams, the groups, the stats and the other keys derived from a topic all follow its prefix,
+// the topics are registered in the registry <namespace>:rsq_topics under their prefixed name.
+type Namespace struct {
+	rsq.ILogger
+	client redis.UniversalClient
+	name   string
+	reg    *Registry
+}
+
+func NewNamespace(name string, cl redis.UniversalClient, l rsq.ILogger) (*Namespace, error) {
+	if err := validNamespace(name); err != nil {
+		return nil, err
+	}
+
+	reg := NewRegistry(cl, l)
+	reg.key = NamespaceKey(name, keyTopicRegistry)
+
+	return &Namespace{ILogger: l, client: cl, name: name, reg: reg}, nil
+}
+
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Key returns the key of topic in the namespace
+func (n *Namespace) Key(topic string) string {
+	return NamespaceKey(n.name, topic)
+}
+
+// Registry returns the registry of the namespace, its topics are named by their key
+func (n *Namespace) Registry() *Registry {
+	return n.reg
+}
+
+// SetQuota sets the quota of the namespace, producers created later apply it
+func (n *Namespace) SetQuota(ctx context.Context, q *Quota) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return kerror.JsonError.Msg(err.Error())
+	}
+
+	return n.client.HSet(ctx, keyNamespaces, n.name, b).Err()
+}
+
+// Quota returns the quota of the namespace, an empty one when none is set
+func (n *Namespace) Quota(ctx context.Context) (*Quota, error) {
+	q := &Quota{}
+
+	b, err := n.client.HGet(ctx, keyNamespaces, n.name).Bytes()
+	if err == redis.Nil {
+		return q, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(b, q); err != nil {
+		return nil, kerror.JsonError.Msg(err.Error())
+	}
+
+	return q, nil
+}
+
+// CreateTopic registers the topic cfg.Name in the namespace, it fails with QuotaExceededError when the namespace has its max topics.
+// The max length of the topic is capped by the quota.
+func (n *Namespace) CreateTopic(ctx context.Context, cfg *TopicConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	q, err := n.Quota(ctx)
+	if err != nil {
+		return err
+	}
+
+	//the namespace is listed once it has a topic
+	if err = n.client.HSetNX(ctx, keyNamespaces, n.name, "{}").Err(); err != nil {
+		return err
+	}
+
+	c := *cfg
+	c.Name = n.Key(cfg.Name)
+	c.MaxLen = q.capLen(c.MaxLen)
+
+	//the max topics are checked with the registration so that concurrent creations can't pass them
+	if err = n.reg.create(ctx, &c, q.MaxTopics); err != nil {
+		return err
+	}
+	cfg.CreatedAt = c.CreatedAt
+
+	return nil
+}
+
+// UpdateTopic replaces the config of the topic cfg.Name in the namespace
+func (n *Namespace) UpdateTopic(ctx context.Context, cfg *TopicConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	q, err := n.Quota(ctx)
+	if err != nil {
+		return err
+	}
+
+	c := *cfg
+	c.Name = n.Key(cfg.Name)
+	c.MaxLen = q.capLen(c.MaxLen)
+
+	return n.reg.UpdateTopic(ctx, &c)
+}
+
+// Topic returns the config of a topic of the namespace
+func (n *Namespace) Topic(ctx context.Context, topic string) (*TopicConfig, error) {
+	return n.reg.Topic(ctx, n.Key(topic))
+}
+
+// Topics returns the names of the topics of the namespace without their prefix
+func (n *Namespace) Topics(ctx context.Context) ([]string, error) {
+	names, err := n.client.HKeys(ctx, n.reg.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, n.Key(""))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// DeleteTopic deletes a topic of the namespace, see Registry.DeleteTopic
+func (n *Namespace) DeleteTopic(ctx context.Context, topic string) error {
+	return n.reg.DeleteTopic(ctx, n.Key(topic))
+}
+
+// config returns the config of a topic of the namespace. A topic which isn't registered is registered with the default config
+// when register is set, so that it counts in the max topics, the default config is returned otherwise.
+func (n *Namespace) config(ctx context.Context, topic string, register bool) (*TopicConfig, error) {
+	cfg, err := n.Topic(ctx, topic)
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.TopicNotFoundError.Code() {
+		return cfg, err
+	}
+
+	if !register {
+		cfg = &TopicConfig{Name: n.Key(topic), MaxLen: defaultStreamLen}
+		return cfg, cfg.validate()
+	}
+
+	err = n.CreateTopic(ctx, &TopicConfig{Name: topic, MaxLen: defaultStreamLen})
+	if ke, ok := err.(*kerror.KError); ok && ke.Code() == kerror.TopicExistsError.Code() {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return n.Topic(ctx, topic)
+}
+
+// NewProducer creates a producer of a topic of the namespace applying its config and the quota, opts override the config.
+// A topic which isn't registered is registered when the quota allows it.
+func (n *Namespace) NewProducer(ctx context.Context, topic string, opts ...Option) (rsq.IMQProducer, error) {
+	cfg, err := n.config(ctx, topic, true)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := n.Quota(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options := cfg.Options()
+	if q.PublishRate > 0 {
+		burst := int64(q.PublishRate)
+		if burst < 1 {
+			burst = 1
+		}
+		rl := redisop.NewTokenBucket(n.client, n.ILogger, q.PublishRate, burst)
+		options = append(options, WithPublishLimit(rl, n.Key(keyNamespacePublish)))
+	}
+
+	return NewProducer(cfg.Name, q.capLen(cfg.MaxLen), n.client, n.ILogger, append(options, opts...)...), nil
+}
+
+// NewConsumer creates a consumer of a topic of the namespace applying its config, opts override the config.
+// A topic which isn't registered is read with the default config.
+func (n *Namespace) NewConsumer(ctx context.Context, topic, name string, opts ...Option) (rsq.IMQConsumer, error) {
+	cfg, err := n.config(ctx, topic, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConsumer(cfg.Name, name, n.client, n.ILogger, append(cfg.Options(), opts...)...), nil
+}
+
+// NewGroup creates a group consumer of a topic of the namespace applying its config, opts override the config.
+// A topic which isn't registered is read with the default config.
+func (n *Namespace) NewGroup(ctx context.Context, topic, group, name string, opts ...Option) (*Group, error) {
+	cfg, err := n.config(ctx, topic, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewGroup(cfg.Name, group, name, n.client, n.ILogger, append(cfg.GroupOptions(group), opts...)...), nil
+}
+
+// capLen caps the max length of a topic, 0 is no trim on write
+func (q *Quota) capLen(maxLen int64) int64 {
+	if q.MaxLen > 0 && (maxLen == 0 || maxLen > q.MaxLen) {
+		return q.MaxLen
+	}
+
+	return maxLen
+}
+
+// Namespaces lists the namespaces having a topic or a quota
+func (a *Admin) Namespaces(ctx context.Context) ([]string, error) {
+	names, err := a.client.HKeys(ctx, keyNamespaces).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// PurgeNamespace deletes the topics registered in the namespace ns with their derived keys, its registry, its publish
+// rate limit and its quota. Keys prefixed with <ns>: which rsq didn't create are left alone.
+// It returns the number of deleted keys.
+func (a *Admin) PurgeNamespace(ctx context.Context, ns string) (int64, error) {
+	if err := validNamespace(ns); err != nil {
+		return 0, err
+	}
+
+	regKey := NamespaceKey(ns, keyTopicRegistry)
+
+	names, err := a.client.HKeys(ctx, regKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{regKey, NamespaceKey(ns, keyNamespacePublish)}
+	for _, name := range names {
+		topic, err := topicKeys(ctx, a.client, name)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, topic...)
+	}
+
+	deleted, err := delKeys(ctx, a.client, keys)
+	if err != nil {
+		return deleted, err
+	}
+
+	return deleted, a.client.HDel(ctx, keyNamespaces, ns).Err()
+}
@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/test"
+	"sync"
+	"testing"
+)
+
+func TestNamespaceKey(t *testing.T) {
+	if k := NamespaceKey("team", "orders"); k != "team:orders" {
+		t.Errorf("unexpected key %s", k)
+	}
+
+	if k := NamespaceKey("", "orders"); k != "orders" {
+		t.Errorf("unexpected key %s", k)
+	}
+
+	for _, ns := range []string{"", "a:b", "a*"} {
+		if _, err := NewNamespace(ns, nil, nil); err == nil {
+			t.Errorf("expect namespace %q invalid", ns)
+		}
+	}
+
+	q := &Quota{MaxLen: 100}
+	if q.capLen(0) != 100 || q.capLen(1000) != 100 || q.capLen(10) != 10 {
+		t.Errorf("unexpected caps of %+v", q)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	c, l := test.Dependency()
+	ctx := context.Background()
+	admin := NewAdmin(c, l)
+
+	_, _ = admin.PurgeNamespace(ctx, "rsq_ns_test")
+
+	ns, err := NewNamespace("rsq_ns_test", c, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ns.SetQuota(ctx, &Quota{MaxTopics: 1, MaxLen: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := ns.NewProducer(ctx, "orders", WithAvailabilityCheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	defer p.Stop()
+
+	if _, err = p.PublishSync(ctx, "1", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.XLen(ctx, "rsq_ns_test:orders").Val(); n != 1 {
+		t.Errorf("expect the message in the namespace, got %d", n)
+	}
+
+	cfg, err := ns.Topic(ctx, "orders")
+	if err != nil || cfg.MaxLen != 100 {
+		t.Errorf("expect the maxlen capped, got %+v %v", cfg, err)
+	}
+
+	//a group reads a topic without registering it
+	g, err := ns.NewGroup(ctx, "payments", "g", "n")
+	if err != nil || g.Topic() != "rsq_ns_test:payments" {
+		t.Fatalf("expect a group of the namespace, got %v", err)
+	}
+	g.Stop()
+
+	if names, _ := ns.Topics(ctx); len(names) != 1 || names[0] != "orders" {
+		t.Errorf("expect only the topic of the producer registered, got %v", names)
+	}
+
+	err = ns.CreateTopic(ctx, &TopicConfig{Name: "payments"})
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.QuotaExceededError.Code() {
+		t.Errorf("expect the quota exceeded, got %v", err)
+	}
+
+	//the mode of the registry of the namespace applies to the plain producers of its topics
+	if err = ns.UpdateTopic(ctx, &TopicConfig{Name: "orders", SingleEntry: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := NewProducer("rsq_ns_test:orders", 100, c, l).(*producer); !p.opts.singleEntry {
+		t.Error("expect the single entry mode of the namespace registry")
+	}
+
+	names, err := admin.Namespaces(ctx)
+	if err != nil || len(names) == 0 {
+		t.Errorf("expect the namespace listed, got %v %v", names, err)
+	}
+
+	//a key of the same prefix which isn't a topic of the namespace
+	c.Set(ctx, "rsq_ns_test:other", "x", 0)
+	defer c.Del(ctx, "rsq_ns_test:other")
+
+	if _, err = admin.PurgeNamespace(ctx, "rsq_ns_test"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"rsq_ns_test:orders", "rsq_ns_test:orders_stat", "rsq_ns_test:rsq_topics"} {
+		if c.Exists(ctx, key).Val() != 0 {
+			t.Errorf("expect %s purged", key)
+		}
+	}
+
+	if c.Exists(ctx, "rsq_ns_test:other").Val() != 1 {
+		t.Error("expect the other key of the prefix kept")
+	}
+}
+
+func TestNamespaceMaxTopics(t *testing.T) {
+	c, l := test.Dependency()
+	ctx := context.Background()
+
+	_, _ = NewAdmin(c, l).PurgeNamespace(ctx, "rsq_ns_max_test")
+	ns, err := NewNamespace("rsq_ns_max_test", c, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ns.SetQuota(ctx, &Quota{MaxTopics: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	//concurrent creations can't pass the max topics together
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = ns.CreateTopic(ctx, &TopicConfig{Name: fmt.Sprintf("t%d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	if names, _ := ns.Topics(ctx); len(names) != 2 {
+		t.Errorf("expect 2 topics, got %v", names)
+	}
+
+	_, _ = NewAdmin(c, l).PurgeNamespace(ctx, "rsq_ns_max_test")
+}
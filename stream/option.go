@@ -0,0 +1,333 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/redisop"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+type options struct {
+	reportInterval   time.Duration
+	reportAlive      time.Duration
+	latencyTolerance int64 //ms
+	lagTolerance     int64 //entries
+	block            time.Duration
+	readCount        int64
+	laneWeights      []int
+	handlerBatchSize int
+	handlerBatchWait time.Duration
+	batchSize        int
+	batchBytes       int
+	linger           time.Duration
+	pipeline         int
+	singleEntry      bool
+	singleEntrySet   bool //set by an option, the mode registered for the topic applies otherwise
+	maxMessageSize   int
+	routing          RoutingMode
+	tag              string
+	maxDeliveries    int64
+	dlqMaxLen        int64
+	retry            RetryPolicy
+	chanSize         int
+	streamLen        int64
+	checkAvailable   bool
+	tracerProvider   trace.TracerProvider
+	rateLimiter      redisop.RateLimiter
+	rateByTag        bool
+	fullPolicy       FullPolicy
+	publishRate      float64
+	publishBurst     int
+	publishLimiter   redisop.RateLimiter
+	publishLimitKey  string
+}
+
+type Option func(o *options)
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		reportInterval:   reportInterval * time.Second,
+		reportAlive:      reportAlive * time.Second,
+		latencyTolerance: latencyTolerance,
+		lagTolerance:     lagTolerance,
+		block:            blockRead * time.Millisecond,
+		readCount:        readCount,
+		handlerBatchSize: handlerBatchSize,
+		handlerBatchWait: handlerBatchWait * time.Millisecond,
+		batchSize:        batchSize,
+		pipeline:         pipelineDepth,
+		chanSize:         chanSize,
+		streamLen:        defaultStreamLen,
+		checkAvailable:   true,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func (o *options) tracer() trace.Tracer {
+	tp := o.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(tracerName)
+}
+
+// throttle waits until the rate limit allows the consumer to handle a message, the message is handled anyway when redis fails
+func (o *options) throttle(topic, tagId string, l rsq.ILogger) {
+	if o.rateLimiter == nil {
+		return
+	}
+
+	key := streamRateKey(topic, "")
+	if o.rateByTag {
+		key = streamRateKey(topic, tagId)
+	}
+
+	if err := o.rateLimiter.Wait(context.Background(), key, 1); err != nil {
+		l.Errorf("rate limit failed topic: %s, tag: %s, err: %s", topic, tagId, err)
+	}
+}
+
+// WithReportInterval sets how often consumers report their stat and the producer checks it
+func WithReportInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.reportInterval = d
+		}
+	}
+}
+
+// WithReportAlive sets how long the producer keeps the stat of a consumer which stopped reporting
+func WithReportAlive(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.reportAlive = d
+		}
+	}
+}
+
+// WithLatencyTolerance sets the latency above which the producer stops publishing to a tag
+func WithLatencyTolerance(d time.Duration) Option {
+	return func(o *options) {
+		o.latencyTolerance = d.Milliseconds()
+	}
+}
+
+// WithLagTolerance sets the unprocessed entries above which the producer stops publishing to a tag
+func WithLagTolerance(entries int64) Option {
+	return func(o *options) {
+		o.lagTolerance = entries
+	}
+}
+
+// WithBlock sets how long a read waits for new entries, 0 waits forever
+func WithBlock(d time.Duration) Option {
+	return func(o *options) {
+		if d >= 0 {
+			o.block = d
+		}
+	}
+}
+
+// WithReadCount sets the max entries returned by a read
+func WithReadCount(n int64) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.readCount = n
+		}
+	}
+}
+
+// WithLaneWeights sets the weights of the lanes read by a priority consumer, from priority 0 up.
+// A lane gets a share of each read in proportion to its weight, 2^priority by default.
+func WithLaneWeights(weights ...int) Option {
+	return func(o *options) {
+		o.laneWeights = weights
+	}
+}
+
+// WithHandlerBatch sets the max messages handed to a batch handler at once,
+// and how long the first of them waits for the batch to fill up
+func WithHandlerBatch(size int, wait time.Duration) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.handlerBatchSize = size
+		}
+		if wait >= 0 {
+			o.handlerBatchWait = wait
+		}
+	}
+}
+
+// WithBatchSize sets the max messages packed into one entry by the producer,
+// or the max entries written in one round trip in the single entry mode
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+// WithMaxBatchBytes sets the max bytes of the messages packed into one entry, 0 for no limit
+func WithMaxBatchBytes(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.batchBytes = n
+		}
+	}
+}
+
+// WithLinger sets how long the producer waits for more messages before sending a batch,
+// 0 sends it as soon as the buffer is empty
+func WithLinger(d time.Duration) Option {
+	return func(o *options) {
+		if d >= 0 {
+			o.linger = d
+		}
+	}
+}
+
+// WithPipeline sets the max entries written by the producer in one round trip
+func WithPipeline(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.pipeline = n
+		}
+	}
+}
+
+// WithPublishLimit caps the publishes of the producers sharing the key of rl,
+// a publish whose context is done while it waits fails with kerror.RateLimitedError
+func WithPublishLimit(rl redisop.RateLimiter, key string) Option {
+	return func(o *options) {
+		o.publishLimiter = rl
+		o.publishLimitKey = key
+	}
+}
+
+// WithSingleEntry sets whether the producer writes each message in its own entry,
+// so that a group acks, claims and dead letters the messages one by one.
+// Without it a producer applies the mode of its topic in the registry, see TopicConfig.SingleEntry,
+// all the producers of a topic should write the same way.
+func WithSingleEntry(single bool) Option {
+	return func(o *options) {
+		o.singleEntry = single
+		o.singleEntrySet = true
+	}
+}
+
+// WithMaxMessageSize sets the max bytes of the data of a message, larger publishes fail with MessageTooLargeError
+func WithMaxMessageSize(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.maxMessageSize = n
+		}
+	}
+}
+
+// WithRouting sets which consumers receive the messages of the producer, RoutingTag by default
+func WithRouting(mode RoutingMode) Option {
+	return func(o *options) {
+		o.routing = mode
+	}
+}
+
+// WithMaxDeliveries sets the deliveries after which a group moves an entry to the dead letter queue instead of handling it, 0 for no limit
+func WithMaxDeliveries(n int64) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.maxDeliveries = n
+		}
+	}
+}
+
+// WithDeadLetterMaxLen sets the approximate max length of the dead letter queue written by a group, 0 for no limit
+func WithDeadLetterMaxLen(n int64) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.dlqMaxLen = n
+		}
+	}
+}
+
+// WithRetry sets how a group retries the messages its handler fails, see RetryPolicy
+func WithRetry(p RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// WithTag sets the tag a consumer receives the messages of, its name by default
+func WithTag(tag string) Option {
+	return func(o *options) {
+		o.tag = tag
+	}
+}
+
+// WithChanSize sets the buffer size of messages waiting to be sent by the producer
+func WithChanSize(n int) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.chanSize = n
+		}
+	}
+}
+
+// WithStreamLen sets the max length used when the topic is created.
+//
+// Deprecated: topics are created empty, they are trimmed by the producers or by a Retention
+func WithStreamLen(n int64) Option {
+	return func(o *options) {
+		if n >= 0 {
+			o.streamLen = n
+		}
+	}
+}
+
+// WithAvailabilityCheck sets whether the producer drops messages of tags without a healthy consumer
+func WithAvailabilityCheck(check bool) Option {
+	return func(o *options) {
+		o.checkAvailable = check
+	}
+}
+
+// WithTracerProvider sets the provider of the publish and process spans, the global one by default
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithRateLimit caps the messages handled by all the consumers of the topic, or of their tag when byTag is set
+func WithRateLimit(rl redisop.RateLimiter, byTag bool) Option {
+	return func(o *options) {
+		o.rateLimiter = rl
+		o.rateByTag = byTag
+	}
+}
+
+// WithFullPolicy sets what a publish does when the buffer of the producer is full, FullBlock by default
+func WithFullPolicy(policy FullPolicy) Option {
+	return func(o *options) {
+		o.fullPolicy = policy
+	}
+}
+
+// WithPublishRate caps the publishes of the producer per second, burst of them may be done at once, rate of them when burst is 0.
+// A publish whose context is done while it waits fails with kerror.RateLimitedError.
+func WithPublishRate(rate float64, burst int) Option {
+	return func(o *options) {
+		if rate > 0 {
+			o.publishRate = rate
+			o.publishBurst = burst
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package stream
+
+import (
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"sync"
+	"time"
+)
+
+// pooledGroup is a group of a GroupPool with the requests using it
+type pooledGroup struct {
+	g    *Group
+	refs int
+	used time.Time
+}
+
+// GroupPool keeps the groups a server pulls with for its remote consumers.
+// A group is acquired for each request and released after it, the groups released for longer than the idle time
+// are stopped, and so are the least recently used ones when the pool is full.
+type GroupPool struct {
+	rsq.ILogger
+
+	client redis.UniversalClient
+	max    int
+	idle   time.Duration
+	opts   []Option
+	groups map[string]*pooledGroup
+	mutex  sync.Mutex
+}
+
+// NewGroupPool creates a pool of up to max groups created with opts
+func NewGroupPool(max int, idle time.Duration, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *GroupPool {
+	return &GroupPool{
+		ILogger: l,
+
+		client: cl,
+		max:    max,
+		idle:   idle,
+		opts:   opts,
+		groups: make(map[string]*pooledGroup),
+	}
+}
+
+// Acquire returns the group consumer name of the group on topic, it fails with QuotaExceededError
+// when the pool is full of groups in use
+func (p *GroupPool) Acquire(topic, group, name string) (*Group, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := topic + "\n" + group + "\n" + name
+
+	pg, ok := p.groups[key]
+	if !ok {
+		p.expire()
+
+		if len(p.groups) >= p.max && !p.evict() {
+			return nil, kerror.QuotaExceededError.Msgf("%d groups in use", len(p.groups))
+		}
+
+		pg = &pooledGroup{g: NewGroup(topic, group, name, p.client, p.ILogger, p.opts...)}
+		p.groups[key] = pg
+	}
+
+	pg.refs++
+	pg.used = time.Now()
+
+	return pg.g, nil
+}
+
+// Release gives back a group acquired by a request
+func (p *GroupPool) Release(g *Group) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if pg, ok := p.groups[g.topic+"\n"+g.group+"\n"+g.name]; ok && pg.g == g {
+		pg.refs--
+		pg.used = time.Now()
+	}
+}
+
+// Len returns the number of groups in the pool
+func (p *GroupPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.groups)
+}
+
+// Close stops the groups of the pool
+func (p *GroupPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for key, pg := range p.groups {
+		pg.g.Stop()
+		delete(p.groups, key)
+	}
+}
+
+// expire stops the groups released for longer than the idle time
+func (p *GroupPool) expire() {
+	for key, pg := range p.groups {
+		if pg.refs == 0 && time.Since(pg.used) > p.idle {
+			pg.g.Stop()
+			delete(p.groups, key)
+		}
+	}
+}
+
+// evict stops the least recently used group not in use, it returns false when all the groups are in use
+func (p *GroupPool) evict() bool {
+	var oldest string
+	for key, pg := range p.groups {
+		if pg.refs == 0 && (oldest == "" || pg.used.Before(p.groups[oldest].used)) {
+			oldest = key
+		}
+	}
+
+	if oldest == "" {
+		return false
+	}
+
+	p.groups[oldest].g.Stop()
+	delete(p.groups, oldest)
+
+	return true
+}
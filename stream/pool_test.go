@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+	"time"
+)
+
+func TestGroupPool(t *testing.T) {
+	c, l := test.Dependency()
+	p := NewGroupPool(2, time.Hour, c, l)
+	defer p.Close()
+
+	a, err := p.Acquire("rsq:pool_test", "g", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := p.Acquire("rsq:pool_test", "g", "a"); again != a {
+		t.Error("expect the group of the pool")
+	}
+	p.Release(a)
+
+	b, err := p.Acquire("rsq:pool_test", "g", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the pool is full of groups in use
+	if _, err = p.Acquire("rsq:pool_test", "g", "c"); err == nil {
+		t.Fatal("expect a full pool")
+	} else if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.QuotaExceededError.Code() {
+		t.Fatalf("expect a quota error, got %v", err)
+	}
+
+	//the released group is evicted for a new one
+	p.Release(a)
+	if _, err = p.Acquire("rsq:pool_test", "g", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Release(b)
+	if again, err := p.Acquire("rsq:pool_test", "g", "a"); err != nil || again == a {
+		t.Errorf("expect the evicted group created again, got %v", err)
+	}
+
+	if n := p.Len(); n != 2 {
+		t.Errorf("expect 2 groups, got %d", n)
+	}
+}
@@ -0,0 +1,225 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"time"
+)
+
+// maxPriorityLevels bounds the lanes of a topic, the default weight of a lane doubles with its priority
+const maxPriorityLevels = 16
+
+func priorityLevels(levels int) int {
+	if levels < 1 {
+		return 1
+	}
+
+	if levels > maxPriorityLevels {
+		return maxPriorityLevels
+	}
+
+	return levels
+}
+
+// laneWeight returns the weight of the lane priority
+func (o *options) laneWeight(priority int) int {
+	if priority < len(o.laneWeights) && o.laneWeights[priority] > 0 {
+		return o.laneWeights[priority]
+	}
+
+	return 1 << priority
+}
+
+// PriorityProducer publishes the messages of a topic to lanes by their priority, from 0 the lowest to levels-1 the highest.
+// A message of priority p goes to the stream LaneTopic(topic, p), the messages of priority 0 to the topic itself,
+// so the consumers which don't read the lanes still get them. The lanes of a topic without hash tag are tagged
+// by the topic, so all the lanes hash to the slot of the topic on a cluster.
+type PriorityProducer struct {
+	topic string
+	lanes []rsq.IMQProducer
+}
+
+// NewPriorityProducer creates a producer of the levels lanes of topic, each lane is trimmed to about maxLen entries
+func NewPriorityProducer(topic string, levels int, maxLen int64, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *PriorityProducer {
+	p := &PriorityProducer{topic: topic}
+
+	//the lanes write the way the topic is registered
+	if !newOptions(opts...).singleEntrySet {
+		opts = append([]Option{WithSingleEntry(registeredSingleEntry(context.Background(), cl, l, topic))}, opts...)
+	}
+
+	for i := 0; i < priorityLevels(levels); i++ {
+		p.lanes = append(p.lanes, NewProducer(LaneTopic(topic, i), maxLen, cl, l, opts...))
+	}
+
+	return p
+}
+
+func (p *PriorityProducer) Topic() string {
+	return p.topic
+}
+
+// Levels returns the number of lanes
+func (p *PriorityProducer) Levels() int {
+	return len(p.lanes)
+}
+
+// Lane returns the producer of a priority, a priority out of the levels is clamped to them
+func (p *PriorityProducer) Lane(priority int) rsq.IMQProducer {
+	if priority < 0 {
+		priority = 0
+	}
+
+	if priority >= len(p.lanes) {
+		priority = len(p.lanes) - 1
+	}
+
+	return p.lanes[priority]
+}
+
+func (p *PriorityProducer) Start() {
+	for _, lane := range p.lanes {
+		lane.Start()
+	}
+}
+
+func (p *PriorityProducer) Stop() {
+	for _, lane := range p.lanes {
+		lane.Stop()
+	}
+}
+
+// Publish publishes a message with the priority 0
+func (p *PriorityProducer) Publish(id string, data []byte, tagIds ...string) error {
+	return p.lanes[0].Publish(id, data, tagIds...)
+}
+
+// PublishCtx publishes a message with the priority 0
+func (p *PriorityProducer) PublishCtx(ctx context.Context, id string, data []byte, header map[string]string, tagIds ...string) error {
+	return p.lanes[0].PublishCtx(ctx, id, data, header, tagIds...)
+}
+
+// PublishSync publishes a message with the priority 0
+func (p *PriorityProducer) PublishSync(ctx context.Context, id string, data []byte, header map[string]string, tagIds ...string) (string, error) {
+	return p.lanes[0].PublishSync(ctx, id, data, header, tagIds...)
+}
+
+// PublishPriority publishes a message to the lane of priority
+func (p *PriorityProducer) PublishPriority(ctx context.Context, priority int, id string, data []byte, header map[string]string, tagIds ...string) error {
+	return p.Lane(priority).PublishCtx(ctx, id, data, header, tagIds...)
+}
+
+// PublishPrioritySync publishes a message to the lane of priority before returning, the id of the stream entry is returned
+func (p *PriorityProducer) PublishPrioritySync(ctx context.Context, priority int, id string, data []byte, header map[string]string, tagIds ...string) (string, error) {
+	return p.Lane(priority).PublishSync(ctx, id, data, header, tagIds...)
+}
+
+// Stats returns the stats of the lanes summed
+func (p *PriorityProducer) Stats() rsq.ProducerStats {
+	var stats rsq.ProducerStats
+
+	for _, lane := range p.lanes {
+		s := lane.Stats()
+		stats.Buffered += s.Buffered
+		stats.Capacity += s.Capacity
+		stats.Published += s.Published
+		stats.Failed += s.Failed
+		stats.Full += s.Full
+		stats.Dropped += s.Dropped
+		stats.Rejected += s.Rejected
+		stats.Throttled += s.Throttled
+	}
+
+	return stats
+}
+
+// PriorityConsumer reads the lanes of a topic, the higher lanes first. Each read gives every lane a share of the
+// read count in proportion to its weight, see WithLaneWeights, so that the lower lanes aren't starved.
+// Every lane keeps its own consumer, the handlers tell the lanes apart by Message.Topic.
+type PriorityConsumer struct {
+	*MultiConsumer
+	topic string
+}
+
+// NewPriorityConsumer creates a broadcast consumer of the levels lanes of topic, see NewConsumer
+func NewPriorityConsumer(topic string, levels int, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *PriorityConsumer {
+	return newPriorityConsumer(topic, levels, "", name, cl, l, opts...)
+}
+
+// NewPriorityGroup creates a consumer of the group on the levels lanes of topic, see NewGroup
+func NewPriorityGroup(topic string, levels int, group, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *PriorityConsumer {
+	return newPriorityConsumer(topic, levels, group, name, cl, l, opts...)
+}
+
+func newPriorityConsumer(topic string, levels int, group, name string, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *PriorityConsumer {
+	m := newMultiConsumer(group, name, cl, l, opts...)
+	m.lanes = true
+
+	for i := 0; i < priorityLevels(levels); i++ {
+		m.add(LaneTopic(topic, i), opts...)
+		m.subs[i].weight = m.opts.laneWeight(i)
+	}
+
+	return &PriorityConsumer{MultiConsumer: m, topic: topic}
+}
+
+func (c *PriorityConsumer) Topic() string {
+	return c.topic
+}
+
+// Lane returns the consumer of the lane priority, nil when it isn't read
+func (c *PriorityConsumer) Lane(priority int) rsq.IMQConsumer {
+	return c.Consumer(LaneTopic(c.topic, priority))
+}
+
+// readLanes reads the lanes from the highest priority down, each of them up to its share of the read count,
+// in a single pipelined round trip. It waits for new entries on all the lanes only when they are all empty.
+func (m *MultiConsumer) readLanes(ctx context.Context, subs []*subscription, block time.Duration) ([]redis.XStream, error) {
+	total := 0
+	for _, s := range subs {
+		total += s.weight
+	}
+
+	cmds := make([]*redis.XStreamSliceCmd, 0, len(subs))
+	_, _ = m.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := len(subs) - 1; i >= 0; i-- {
+			s := subs[i]
+
+			count := m.opts.readCount * int64(s.weight) / int64(total)
+			if count < 1 {
+				count = 1
+			}
+
+			cmds = append(cmds, m.xReadCmd(ctx, pipe, []string{s.consumer.Topic(), s.id}, count, -1))
+		}
+		return nil
+	})
+
+	var data []redis.XStream
+	for _, cmd := range cmds {
+		result, err := cmd.Result()
+		if err != nil && err != redis.Nil {
+			//the entries read from the other lanes are handled before retrying
+			if len(data) > 0 {
+				m.Errorf("MultiConsumer:readLanes: %s, name: %s", err, m.FullName())
+				return data, nil
+			}
+			return nil, err
+		}
+
+		data = append(data, result...)
+	}
+
+	if len(data) > 0 {
+		return data, nil
+	}
+
+	//the higher lanes first, a read returns the streams in their order
+	lanes := make([]*subscription, 0, len(subs))
+	for i := len(subs) - 1; i >= 0; i-- {
+		lanes = append(lanes, subs[i])
+	}
+
+	return m.xRead(ctx, streams(lanes), m.opts.readCount, block)
+}
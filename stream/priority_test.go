@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLaneWeight(t *testing.T) {
+	if LaneTopic("t", 0) != "t" || LaneTopic("t", 2) != "{t}_lane_2" || LaneTopic("{rsq}:t", 2) != "{rsq}:t_lane_2" {
+		t.Fatalf("lanes %s %s %s", LaneTopic("t", 0), LaneTopic("t", 2), LaneTopic("{rsq}:t", 2))
+	}
+
+	o := newOptions()
+	if o.laneWeight(0) != 1 || o.laneWeight(3) != 8 {
+		t.Fatalf("default weights %d %d", o.laneWeight(0), o.laneWeight(3))
+	}
+
+	o = newOptions(WithLaneWeights(1, 0, 5))
+	if o.laneWeight(0) != 1 || o.laneWeight(1) != 2 || o.laneWeight(2) != 5 {
+		t.Fatalf("weights %d %d %d", o.laneWeight(0), o.laneWeight(1), o.laneWeight(2))
+	}
+}
+
+func TestPriorityGroup(t *testing.T) {
+	topic := "{rsq}:priority_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	_ = NewRegistry(c, l).DeleteTopic(ctx, topic)
+
+	g := NewPriorityGroup(topic, 2, "g", "n", c, l, WithReadCount(10), WithBlock(100*time.Millisecond))
+
+	var mutex sync.Mutex
+	var order []string
+	g.SetMessageHandler(func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		order = append(order, string(msg.Data))
+		return nil
+	})
+	g.Subscribe()
+	defer g.Stop()
+
+	//the backlog of both lanes is read at once after the resume
+	g.Pause()
+	time.Sleep(200 * time.Millisecond)
+
+	p := NewPriorityProducer(topic, 2, 100, c, l, WithSingleEntry(true), WithAvailabilityCheck(false))
+	for i := 0; i < 20; i++ {
+		if _, err := p.PublishPrioritySync(ctx, 0, "", []byte("L"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := p.PublishPrioritySync(ctx, 1, "", []byte("H"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g.Resume()
+	time.Sleep(2 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	//each read gets 6 entries of the high lane and 3 of the low one
+	if got := strings.Join(order, ""); !strings.HasPrefix(got, "HHHHHHLLLHHHHHH") || len(got) != 40 {
+		t.Errorf("unexpected order %s", got)
+	}
+}
@@ -2,10 +2,12 @@ package stream
 
 import (
 	"context"
-	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
 	"github.com/wsk15046/rsq/redisop"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
 	"time"
 )
@@ -17,13 +19,28 @@ type producer struct {
 	client   redis.UniversalClient
 	maxLen   int64
 	sendChan chan *MsgNode
+	opts     *options
+	tracer   trace.Tracer
 
 	availableTags map[string]bool
 	mutex         *sync.RWMutex
 	quit          chan bool
+
+	election *redisop.Election
+	cancel   context.CancelFunc
+
+	limiter *localLimiter
+	flow    flowStats
 }
 
-func NewProducer(topic string, maxLen int64, cl redis.UniversalClient, l rsq.ILogger) rsq.IMQProducer {
+// NewProducer creates the producer of topic, each write trims it to about maxLen entries,
+// a maxLen of 0 leaves the trimming to a Retention
+func NewProducer(topic string, maxLen int64, cl redis.UniversalClient, l rsq.ILogger, opts ...Option) rsq.IMQProducer {
+
+	o := newOptions(opts...)
+	if !o.singleEntrySet {
+		o.singleEntry = registeredSingleEntry(context.Background(), cl, l, topic)
+	}
 
 	p := &producer{
 		ILogger: l,
@@ -31,76 +48,90 @@ func NewProducer(topic string, maxLen int64, cl redis.UniversalClient, l rsq.ILo
 		topic:         topic,
 		client:        cl,
 		maxLen:        maxLen,
-		sendChan:      make(chan *MsgNode, 20480),
+		sendChan:      make(chan *MsgNode, o.chanSize),
+		opts:          o,
+		tracer:        o.tracer(),
 		mutex:         new(sync.RWMutex),
 		availableTags: make(map[string]bool),
 		quit:          make(chan bool),
+		election:      redisop.NewElection(cl, l, streamMonitorKey(topic), 3*o.reportInterval),
+	}
+
+	if o.publishRate > 0 {
+		p.limiter = newLocalLimiter(o.publishRate, o.publishBurst)
 	}
 
-	createTopic(topic, cl)
+	if e := createTopic(context.Background(), cl, topic); e != nil {
+		l.Warnf("create topic failed [ %s ]", e.Error())
+	}
 
 	return p
 }
 
 func (p *producer) Start() {
 
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.election.Run(ctx)
+
 	p.monitor()
 
 	go func() {
 
-		const nBatchInit = 128
+		//batched send message, the batch is flushed when it is full, when its linger runs out,
+		//or as soon as the buffer is empty without linger
+		b := newBatch(p.opts)
 
-		//batched send message
-		batchSize := nBatchInit
+		var linger *time.Timer
+		var lingerC <-chan time.Time
 
-		m := make(map[string]interface{}, batchSize)
-		index := 0
+		flush := func() {
+			if linger != nil {
+				linger.Stop()
+				linger, lingerC = nil, nil
+			}
+			p.flush(b)
+		}
 
 		for {
+			if p.opts.linger <= 0 && !b.empty() && len(p.sendChan) == 0 {
+				flush()
+			}
+
 			select {
 			case <-p.quit:
+				flush()
 				p.Info("stop producer %s", p.Topic())
 				return
 			case node := <-p.sendChan:
-				cid := fmt.Sprintf("%s-%d-%s", node.Id, index, node.TagId)
-				m[cid] = node.Data
-				index++
-
-				if len(m) >= batchSize {
-					_, err := p.xAdd(m, p.maxLen)
-					if err != nil {
-						p.Errorf("MQProducer publish failed error: %s", err.Error())
-					}
-
-					m = make(map[string]interface{}, batchSize)
-					index = 0
+				if b.empty() && p.opts.linger > 0 {
+					linger = time.NewTimer(p.opts.linger)
+					lingerC = linger.C
 				}
-			default:
-				if len(m) > 0 {
-					_, err := p.xAdd(m, p.maxLen)
-					if err != nil {
-						p.Errorf("MQProducer publish failed error: %s", err.Error())
-					}
-
-					m = make(map[string]interface{}, batchSize)
-					index = 0
-				} else {
-					select {
-					case <-p.quit:
-						p.Info("stop producer %s", p.Topic())
-						return
-					case node := <-p.sendChan:
-						cid := fmt.Sprintf("%s-%d-%s", node.Id, index, node.TagId)
-						m[cid] = node.Data
-						index++
-					}
+
+				if b.add(node) {
+					flush()
 				}
+			case <-lingerC:
+				flush()
 			}
 		}
 
 	}()
 }
 
+// send writes a batch of count messages
+func (p *producer) send(m map[string]interface{}, count int) {
+	_, err := p.xAdd(context.Background(), m, p.maxLen)
+	if err != nil {
+		p.flow.failed.Add(int64(count))
+		p.Errorf("MQProducer publish failed error: %s", err.Error())
+		return
+	}
+
+	p.flow.published.Add(int64(count))
+}
+
 func (p *producer) monitor() {
 
 	singleMonitor := func() {
@@ -116,14 +147,19 @@ func (p *producer) monitor() {
 		m := make(map[string]bool)
 
 		for k, v := range h {
-			if v.UpdateTime.Add(reportAlive * time.Second).Before(now) {
+			if v.UpdateTime.Add(p.opts.reportAlive).Before(now) {
+				// a single producer of the topic prunes the stale stats
+				if !p.election.Leader() {
+					continue
+				}
+
 				err = r.Del(statKey, k)
 				if err != nil {
 					p.Errorf("del hash field failed %s %s %s", statKey, k, err)
 					continue
 				}
 			} else {
-				if v.Latency <= latencyTolerance {
+				if v.Latency <= p.opts.latencyTolerance && v.Lag+v.Pending <= p.opts.lagTolerance {
 					m[v.TagId] = true
 				}
 			}
@@ -138,7 +174,7 @@ func (p *producer) monitor() {
 
 	go func() {
 		for {
-			time.Sleep(reportInterval * time.Second)
+			time.Sleep(p.opts.reportInterval)
 			singleMonitor()
 		}
 	}()
@@ -146,6 +182,10 @@ func (p *producer) monitor() {
 }
 
 func (p *producer) available(tagId string) bool {
+	if !p.opts.checkAvailable {
+		return true
+	}
+
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
@@ -162,12 +202,28 @@ func (p *producer) available(tagId string) bool {
 	return false
 }
 
+// route returns the tags a message is written for, a message without tag or of a broadcast topic is for all the consumers
+func (p *producer) route(tagIds []string) []string {
+	if len(tagIds) == 0 || p.opts.routing == RoutingBroadcast {
+		return []string{tagIdAll}
+	}
+
+	return tagIds
+}
+
+func (p *producer) checkSize(data []byte) error {
+	if p.opts.maxMessageSize > 0 && len(data) > p.opts.maxMessageSize {
+		return kerror.MessageTooLargeError.Msgf("topic: %s, size: %d, max: %d", p.topic, len(data), p.opts.maxMessageSize)
+	}
+
+	return nil
+}
+
 func (p *producer) Topic() string {
 	return p.topic
 }
 
-func (p *producer) xAdd(data interface{}, maxLen int64) (id string, err error) {
-	ctx := context.Background()
+func (p *producer) xAdd(ctx context.Context, data interface{}, maxLen int64) (id string, err error) {
 	id, err = p.client.XAdd(ctx, &redis.XAddArgs{
 		Stream: p.topic,
 		MaxLen: maxLen,
@@ -181,37 +237,136 @@ func (p *producer) xAdd(data interface{}, maxLen int64) (id string, err error) {
 
 func (p *producer) Stop() {
 	p.quit <- true
+
+	if p.cancel != nil {
+		p.cancel()
+	}
 }
 
 // Publish targetId
 func (p *producer) Publish(Id string, data []byte, tagIds ...string) error {
+	return p.PublishCtx(context.Background(), Id, data, nil, tagIds...)
+}
+
+// PublishCtx publishes with headers, the trace context of ctx is injected into them
+func (p *producer) PublishCtx(ctx context.Context, Id string, data []byte, header map[string]string, tagIds ...string) error {
+
+	_, span, header := startPublishSpan(ctx, p.tracer, p.topic, Id, header)
+	defer span.End()
 
-	if len(tagIds) == 0 {
-		tagId := tagIdAll
+	if err := p.checkSize(data); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if err := p.throttle(ctx); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	tagIds = p.route(tagIds)
+
+	for _, tagId := range tagIds {
 		if p.available(tagId) {
 			node := &MsgNode{
-				Id:    Id,
-				TagId: tagId,
-				Data:  data,
+				Id:     Id,
+				TagId:  tagId,
+				Data:   data,
+				Header: header,
 			}
 
-			p.sendChan <- node
-
+			if err := p.enqueue(ctx, node); err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return err
+			}
 		}
-	} else {
-		for _, tagId := range tagIds {
-			if p.available(tagId) {
-				node := &MsgNode{
-					Id:    Id,
-					TagId: tagId,
-					Data:  data,
-				}
+	}
+
+	return nil
+}
+
+// PublishSync bypasses the batching, the message is written in its own entry before returning.
+// In the single entry mode a message with several tags is written in an entry per tag, the id of the first one is returned.
+func (p *producer) PublishSync(ctx context.Context, Id string, data []byte, header map[string]string, tagIds ...string) (string, error) {
+
+	ctx, span, header := startPublishSpan(ctx, p.tracer, p.topic, Id, header)
+	defer span.End()
+
+	if err := p.checkSize(data); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
+
+	if err := p.throttle(ctx); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
+	}
 
-				p.sendChan <- node
+	tagIds = p.route(tagIds)
+
+	var entries []map[string]interface{}
+	index := 0
+
+	for _, tagId := range tagIds {
+		if p.available(tagId) {
+			//a message per tag in the single entry mode
+			if len(entries) == 0 || p.opts.singleEntry {
+				entries = append(entries, make(map[string]interface{}))
+				index = 0
 			}
+
+			appendMsg(entries[len(entries)-1], index, &MsgNode{
+				Id:     Id,
+				TagId:  tagId,
+				Data:   data,
+				Header: header,
+			})
+			index++
 		}
+	}
 
+	if len(entries) == 0 {
+		err := kerror.UnavailableError.Msgf("no available consumer, topic: %s, tags: %v", p.topic, tagIds)
+		span.SetStatus(codes.Error, err.Error())
+		return "", err
 	}
 
-	return nil
+	if len(entries) > 1 {
+		return p.publishEntries(ctx, span, entries)
+	}
+
+	entryId, err := p.xAdd(ctx, entries[0], p.maxLen)
+	if err != nil {
+		p.flow.failed.Add(int64(index))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", kerror.RedisError.Msg(err.Error())
+	}
+
+	p.flow.published.Add(int64(index))
+
+	return entryId, nil
+}
+
+// publishEntries writes the entries of the single entry mode, holding a message each, and returns the id of the first one
+func (p *producer) publishEntries(ctx context.Context, span trace.Span, entries []map[string]interface{}) (string, error) {
+	entryIds, errs := p.xAdds(ctx, entries)
+
+	var err error
+	for _, e := range errs {
+		if e != nil {
+			p.flow.failed.Add(1)
+			err = e
+		} else {
+			p.flow.published.Add(1)
+		}
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return "", kerror.RedisError.Msg(err.Error())
+	}
+
+	return entryIds[0], nil
 }
@@ -0,0 +1,178 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"time"
+)
+
+const (
+	// nackConsumer holds the nacked entries of a group until a consumer claims them
+	nackConsumer = "_nacked"
+	// nackIdle is the idle time given to nacked entries, longer than any sensible ack timeout
+	nackIdle = 365 * 24 * time.Hour
+)
+
+// The pull API lets the caller read and ack entries itself instead of running Subscribe.
+// An entry is acked as a whole, acking one of its messages acks all the messages batched in it,
+// they are flagged Batched. A producer in the single entry mode writes an entry per message instead.
+
+// Fetch reads up to count new entries for the consumer and returns their messages without acking them.
+// Entries without any message for the group are acked directly.
+func (g *Group) Fetch(ctx context.Context, count int64, block time.Duration) ([]*rsq.Message, error) {
+	g.reportOnce.Do(g.cr.StartReport)
+
+	if g.cr.Paused() {
+		wait := block
+		if wait <= 0 || wait > g.cr.interval {
+			wait = g.cr.interval
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+
+		return nil, nil
+	}
+
+	data, err := g.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    g.group,
+		Consumer: g.name,
+		Streams:  []string{g.topic, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []redis.XMessage
+	for _, result := range data {
+		msgs = append(msgs, result.Messages...)
+	}
+
+	return g.pulled(ctx, msgs, nil)
+}
+
+// ClaimIdle transfers to the consumer up to count entries of the group pending longer than minIdle,
+// so that entries never acked by a dead consumer are delivered again.
+// Entries delivered more than the max deliveries of the group are moved to the dead letter queue instead.
+func (g *Group) ClaimIdle(ctx context.Context, minIdle time.Duration, count int64) ([]*rsq.Message, error) {
+	pending, err := g.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: g.topic,
+		Group:  g.group,
+		Idle:   minIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	// go-redis v8 returns redis.Nil for an empty pending list
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(pending))
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+		// XCLAIM increments the delivery count
+		deliveries[p.ID] = p.RetryCount + 1
+	}
+
+	msgs, err := g.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   g.topic,
+		Group:    g.group,
+		Consumer: g.name,
+		MinIdle:  minIdle,
+		Messages: ids,
+	}).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return g.pulled(ctx, msgs, deliveries)
+}
+
+// Ack acks entries by their id, the EntryId of their messages
+func (g *Group) Ack(ctx context.Context, entryIds ...string) (int64, error) {
+	if len(entryIds) == 0 {
+		return 0, nil
+	}
+
+	return g.xAck(ctx, entryIds...)
+}
+
+// Nack gives entries back to the group, they no longer count in the pending entries of the consumer
+// and ClaimIdle of any consumer of the group claims them right away.
+// Their delivery count is kept, the next delivery increments it.
+func (g *Group) Nack(ctx context.Context, entryIds ...string) (int64, error) {
+	if len(entryIds) == 0 {
+		return 0, nil
+	}
+
+	args := []interface{}{"XCLAIM", g.topic, g.group, nackConsumer, 0}
+	for _, id := range entryIds {
+		args = append(args, id)
+	}
+	args = append(args, "IDLE", nackIdle.Milliseconds(), "JUSTID")
+
+	ids, err := g.client.Do(ctx, args...).StringSlice()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(ids)), nil
+}
+
+// DeadLetter moves entries to the dead letter queue of the topic and acks them
+func (g *Group) DeadLetter(ctx context.Context, reason string, entryIds ...string) (int64, error) {
+	return deadLetter(ctx, g.client, g.topic, g.group, reason, g.opts.dlqMaxLen, entryIds...)
+}
+
+// pulled decodes the entries read by the consumer, deliveries is nil for new entries
+func (g *Group) pulled(ctx context.Context, entries []redis.XMessage, deliveries map[string]int64) ([]*rsq.Message, error) {
+	var msgs []*rsq.Message
+	var empty []string
+
+	for _, message := range entries {
+		deliveryCount := int64(1)
+		if deliveries != nil {
+			deliveryCount = deliveries[message.ID]
+		}
+
+		if g.deadLettered(ctx, message.ID, deliveryCount) {
+			continue
+		}
+
+		decoded := g.decode(message, deliveryCount)
+		if len(decoded) == 0 {
+			empty = append(empty, message.ID)
+			continue
+		}
+
+		msgs = append(msgs, decoded...)
+	}
+
+	if l := len(entries); l > 0 {
+		g.cr.Update(entries[l-1].ID, int64(len(msgs)))
+	}
+
+	if _, err := g.Ack(ctx, empty...); err != nil {
+		return msgs, err
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,370 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RoutingMode tells which consumers receive a message
+type RoutingMode string
+
+const (
+	// RoutingTag sends a message to the consumers of its tags, to all of them without tag
+	RoutingTag RoutingMode = "tag"
+	// RoutingBroadcast sends every message to all the consumers whatever its tags
+	RoutingBroadcast RoutingMode = "broadcast"
+)
+
+// DeadLetterConfig sets how the groups of a topic use its dead letter queue
+type DeadLetterConfig struct {
+	MaxDeliveries int64 `json:"maxDeliveries,omitempty"` //deliveries after which an entry is dead lettered, 0 for no limit
+	MaxLen        int64 `json:"maxLen,omitempty"`        //approximate max length of the queue, 0 for no limit
+}
+
+// TopicConfig is the configuration of a topic kept in the registry
+type TopicConfig struct {
+	Name           string                 `json:"name"`
+	MaxLen         int64                  `json:"maxLen,omitempty"` //trims the topic on each write, 0 leaves it to the retention
+	Retention      RetentionPolicy        `json:"retention"`
+	Partitions     int                    `json:"partitions"`               //streams the topic is spread on by the clients partitioning it
+	Codec          string                 `json:"codec,omitempty"`          //encoding of the data, for the clients
+	MaxMessageSize int                    `json:"maxMessageSize,omitempty"` //bytes of the data of a message, 0 for no limit
+	Routing        RoutingMode            `json:"routing"`
+	SingleEntry    bool                   `json:"singleEntry,omitempty"` //an entry per message, applied by all the producers of the topic, see WithSingleEntry
+	DeadLetter     DeadLetterConfig       `json:"deadLetter"`
+	Retry          RetryPolicy            `json:"retry"`
+	GroupRetry     map[string]RetryPolicy `json:"groupRetry,omitempty"` //retry policies of some groups overriding Retry
+	CreatedAt      time.Time              `json:"createdAt"`
+}
+
+// Options returns the options applying the config to the producers and the consumers of the topic
+func (c *TopicConfig) Options() []Option {
+	return []Option{
+		WithSingleEntry(c.SingleEntry),
+		WithMaxMessageSize(c.MaxMessageSize),
+		WithRouting(c.Routing),
+		WithMaxDeliveries(c.DeadLetter.MaxDeliveries),
+		WithDeadLetterMaxLen(c.DeadLetter.MaxLen),
+		WithRetry(c.Retry),
+	}
+}
+
+// GroupOptions returns the options applying the config to the consumers of group
+func (c *TopicConfig) GroupOptions(group string) []Option {
+	opts := c.Options()
+	if p, ok := c.GroupRetry[group]; ok {
+		opts = append(opts, WithRetry(p))
+	}
+
+	return opts
+}
+
+func (c *TopicConfig) validate() error {
+	if c.Name == "" {
+		return kerror.SystemError.Msg("empty topic name")
+	}
+
+	if c.Partitions <= 0 {
+		c.Partitions = 1
+	}
+
+	switch c.Routing {
+	case "":
+		c.Routing = RoutingTag
+	case RoutingTag, RoutingBroadcast:
+	default:
+		return kerror.SystemError.Msgf("invalid routing %s of topic %s", c.Routing, c.Name)
+	}
+
+	return nil
+}
+
+// TopicDescription is the config and the state of a topic
+type TopicDescription struct {
+	Config      *TopicConfig //nil when the topic isn't registered
+	Info        *StreamInfo  //nil when the stream doesn't exist
+	Groups      []*GroupInfo
+	DeadLetters int64
+}
+
+// Registry keeps the configs of the topics in redis.
+// Producers and consumers created by the registry apply the config of their topic.
+type Registry struct {
+	rsq.ILogger
+	client redis.UniversalClient
+	key    string
+}
+
+func NewRegistry(cl redis.UniversalClient, l rsq.ILogger) *Registry {
+	return &Registry{ILogger: l, client: cl, key: keyTopicRegistry}
+}
+
+// CreateTopic registers cfg and creates the stream of the topic, it fails with TopicExistsError when the topic is registered
+func (r *Registry) CreateTopic(ctx context.Context, cfg *TopicConfig) error {
+	return r.create(ctx, cfg, 0)
+}
+
+// registerTopicScript registers a topic unless the registry has max topics, 0 is no limit.
+// It returns 1 when the topic is registered, 0 when it already is and -1 when the registry is full.
+const registerTopicScript = `
+	if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 1 then
+		return 0
+	end
+	local max = tonumber(ARGV[3])
+	if max > 0 and redis.call("HLEN", KEYS[1]) >= max then
+		return -1
+	end
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+`
+
+var registerTopic = redis.NewScript(registerTopicScript)
+
+// create registers cfg when the registry has less than maxTopics topics, 0 is no limit, and creates the stream of the topic
+func (r *Registry) create(ctx context.Context, cfg *TopicConfig, maxTopics int) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	cfg.CreatedAt = time.Now()
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return kerror.JsonError.Msg(err.Error())
+	}
+
+	n, err := registerTopic.Run(ctx, r.client, []string{r.key}, cfg.Name, b, maxTopics).Int()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return kerror.TopicExistsError.Msgf("topic: %s", cfg.Name)
+	}
+
+	if n < 0 {
+		return kerror.QuotaExceededError.Msgf("%d topics registered in %s", maxTopics, r.key)
+	}
+
+	return createTopic(ctx, r.client, cfg.Name)
+}
+
+// UpdateTopic replaces the config of a registered topic, the producers and the consumers created later apply it
+func (r *Registry) UpdateTopic(ctx context.Context, cfg *TopicConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	old, err := r.Topic(ctx, cfg.Name)
+	if err != nil {
+		return err
+	}
+	cfg.CreatedAt = old.CreatedAt
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return kerror.JsonError.Msg(err.Error())
+	}
+
+	return r.client.HSet(ctx, r.key, cfg.Name, b).Err()
+}
+
+// Topic returns the config of a registered topic, it fails with TopicNotFoundError otherwise
+func (r *Registry) Topic(ctx context.Context, name string) (*TopicConfig, error) {
+	b, err := r.client.HGet(ctx, r.key, name).Bytes()
+	if err == redis.Nil {
+		return nil, kerror.TopicNotFoundError.Msgf("topic: %s", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &TopicConfig{}
+	if err = json.Unmarshal(b, cfg); err != nil {
+		return nil, kerror.JsonError.Msg(err.Error())
+	}
+
+	return cfg, nil
+}
+
+// Topics returns the configs of the registered topics sorted by name
+func (r *Registry) Topics(ctx context.Context) ([]*TopicConfig, error) {
+	m, err := r.client.HGetAll(ctx, r.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make([]*TopicConfig, 0, len(m))
+	for name, v := range m {
+		cfg := &TopicConfig{}
+		if err = json.Unmarshal([]byte(v), cfg); err != nil {
+			r.Errorf("invalid config of topic %s: %s", name, err)
+			continue
+		}
+		topics = append(topics, cfg)
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+
+	return topics, nil
+}
+
+// registeredSingleEntry tells whether topic is registered with an entry per message,
+// in the registry of the namespace its prefix names first, then in the default registry
+func registeredSingleEntry(ctx context.Context, client redis.UniversalClient, l rsq.ILogger, topic string) bool {
+	registries := []string{keyTopicRegistry}
+	if i := strings.IndexByte(topic, ':'); i > 0 && validNamespace(topic[:i]) == nil {
+		registries = append([]string{NamespaceKey(topic[:i], keyTopicRegistry)}, registries...)
+	}
+
+	for _, key := range registries {
+		b, err := client.HGet(ctx, key, topic).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			l.Warnf("get config of topic %s failed [ %s ]", topic, err)
+			return false
+		}
+
+		cfg := &TopicConfig{}
+		if err = json.Unmarshal(b, cfg); err != nil {
+			l.Warnf("invalid config of topic %s: %s", topic, err)
+			return false
+		}
+
+		return cfg.SingleEntry
+	}
+
+	return false
+}
+
+// DeleteTopic deletes the stream of a topic with its stats, controls, dead letters, rate limits, retries and priority lanes,
+// and unregisters it.
+// Topics which aren't registered are deleted too.
+func (r *Registry) DeleteTopic(ctx context.Context, name string) error {
+	keys, err := topicKeys(ctx, r.client, name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = delKeys(ctx, r.client, keys); err != nil {
+		return err
+	}
+
+	return r.client.HDel(ctx, r.key, name).Err()
+}
+
+// topicKeys returns the stream of a topic and the keys derived from it
+func topicKeys(ctx context.Context, client redis.UniversalClient, name string) ([]string, error) {
+	keys := []string{name, streamStatKey(name), streamCtlKey(name), streamDLQKey(name), streamMonitorKey(name), streamRateKey(name, "")}
+
+	for _, pattern := range []string{streamRateKey(name, "*"), streamRetryKey(name, "*"), streamLanesPattern(name)} {
+		found, err := scanKeys(ctx, client, pattern, "")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+
+	return keys, nil
+}
+
+// delKeys deletes keys one by one since they may be on different slots of a cluster, it returns the number of deleted keys
+func delKeys(ctx context.Context, client redis.UniversalClient, keys []string) (int64, error) {
+	deleted := int64(0)
+
+	for _, key := range keys {
+		n, err := client.Del(ctx, key).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	return deleted, nil
+}
+
+// DescribeTopic returns the config and the state of a topic
+func (r *Registry) DescribeTopic(ctx context.Context, name string) (*TopicDescription, error) {
+	d := &TopicDescription{}
+
+	cfg, err := r.Topic(ctx, name)
+	if ke, ok := err.(*kerror.KError); ok && ke.Code() == kerror.TopicNotFoundError.Code() {
+		err = nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	d.Config = cfg
+
+	n, err := r.client.Exists(ctx, name).Result()
+	if err != nil || n == 0 {
+		return d, err
+	}
+
+	if d.Info, err = xInfoStream(ctx, r.client, name); err != nil {
+		return nil, err
+	}
+
+	if d.Groups, err = xInfoGroups(ctx, r.client, name); err != nil {
+		return nil, err
+	}
+
+	if d.DeadLetters, err = r.client.XLen(ctx, streamDLQKey(name)).Result(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// config returns the config of a topic, the default one when it isn't registered
+func (r *Registry) config(ctx context.Context, topic string) (*TopicConfig, error) {
+	cfg, err := r.Topic(ctx, topic)
+	if ke, ok := err.(*kerror.KError); ok && ke.Code() == kerror.TopicNotFoundError.Code() {
+		return &TopicConfig{Name: topic, MaxLen: defaultStreamLen}, nil
+	}
+
+	return cfg, err
+}
+
+// NewProducer creates a producer of topic configured by the registry, opts override the config
+func (r *Registry) NewProducer(ctx context.Context, topic string, opts ...Option) (rsq.IMQProducer, error) {
+	cfg, err := r.config(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewProducer(topic, cfg.MaxLen, r.client, r.ILogger, append(cfg.Options(), opts...)...), nil
+}
+
+// NewConsumer creates a consumer of topic configured by the registry, opts override the config
+func (r *Registry) NewConsumer(ctx context.Context, topic, name string, opts ...Option) (rsq.IMQConsumer, error) {
+	cfg, err := r.config(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConsumer(topic, name, r.client, r.ILogger, append(cfg.Options(), opts...)...), nil
+}
+
+// NewGroup creates a group consumer of topic configured by the registry, opts override the config
+func (r *Registry) NewGroup(ctx context.Context, topic, group, name string, opts ...Option) (*Group, error) {
+	cfg, err := r.config(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewGroup(topic, group, name, r.client, r.ILogger, append(cfg.GroupOptions(group), opts...)...), nil
+}
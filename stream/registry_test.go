@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq/kerror"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+)
+
+func TestTopicConfig(t *testing.T) {
+	cfg := &TopicConfig{Name: "rsq:registry_test", MaxMessageSize: 4, Routing: RoutingBroadcast}
+	if err := cfg.validate(); err != nil || cfg.Partitions != 1 {
+		t.Fatalf("unexpected config %+v %v", cfg, err)
+	}
+
+	p := &producer{topic: cfg.Name, opts: newOptions(cfg.Options()...)}
+
+	if tags := p.route([]string{"a", "b"}); len(tags) != 1 || tags[0] != tagIdAll {
+		t.Errorf("expect a broadcast, got %v", tags)
+	}
+
+	if ke, ok := p.checkSize([]byte("12345")).(*kerror.KError); !ok || ke.Code() != kerror.MessageTooLargeError.Code() {
+		t.Errorf("expect a too large message")
+	}
+
+	if err := (&TopicConfig{Name: "x", Routing: "random"}).validate(); err == nil {
+		t.Error("expect an invalid routing")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	topic := "rsq:registry_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	r := NewRegistry(c, l)
+
+	_ = r.DeleteTopic(ctx, topic)
+
+	if err := r.CreateTopic(ctx, &TopicConfig{Name: topic, SingleEntry: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.CreateTopic(ctx, &TopicConfig{Name: topic})
+	if ke, ok := err.(*kerror.KError); !ok || ke.Code() != kerror.TopicExistsError.Code() {
+		t.Fatalf("expect an existing topic, got %v", err)
+	}
+
+	d, err := r.DescribeTopic(ctx, topic)
+	if err != nil || d.Config == nil || !d.Config.SingleEntry || d.Info == nil || d.Info.Length != 0 {
+		t.Fatalf("unexpected description %+v %v", d, err)
+	}
+
+	//a producer created without the registry writes the way the topic is registered, unless told otherwise
+	if p := NewProducer(topic, 100, c, l).(*producer); !p.opts.singleEntry {
+		t.Error("expect the registered single entry mode")
+	}
+
+	if p := NewProducer(topic, 100, c, l, WithSingleEntry(false)).(*producer); p.opts.singleEntry {
+		t.Error("expect the mode of the option")
+	}
+
+	if err = r.DeleteTopic(ctx, topic); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := c.Exists(ctx, topic).Val(); n != 0 {
+		t.Errorf("expect the stream deleted")
+	}
+}
@@ -0,0 +1,293 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/redisop"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy tells which entries of a topic are trimmed, a zero limit is no limit
+type RetentionPolicy struct {
+	MaxAge    time.Duration `json:"maxAge,omitempty"` //entries older than MaxAge are trimmed with XTRIM MINID
+	MaxLen    int64         `json:"maxLen,omitempty"`
+	MaxMemory int64         `json:"maxMemory,omitempty"` //bytes of the stream reported by MEMORY USAGE
+	Safe      bool          `json:"safe,omitempty"`      //never trims past the last delivered entry of the slowest group, nor its pending entries
+	Exact     bool          `json:"exact,omitempty"`     //trims exactly, whole nodes of the stream are trimmed by default which is cheaper
+}
+
+// Retain trims topic by policy once and returns the number of deleted entries
+func (a *Admin) Retain(ctx context.Context, topic string, policy RetentionPolicy) (int64, error) {
+	safeId := ""
+	if policy.Safe {
+		id, err := a.safeId(ctx, topic)
+		if err != nil {
+			return 0, err
+		}
+
+		// a group hasn't delivered anything yet
+		if id == "0-0" {
+			return 0, nil
+		}
+		safeId = id
+	}
+
+	deleted := int64(0)
+
+	if policy.MaxAge > 0 {
+		minId := fmt.Sprintf("%d-0", time.Now().Add(-policy.MaxAge).UnixMilli())
+		if safeId != "" && compareStreamId(safeId, minId) < 0 {
+			minId = safeId
+		}
+
+		n, err := a.trimMinId(ctx, topic, minId, policy.Exact)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	if policy.MaxLen <= 0 && policy.MaxMemory <= 0 {
+		return deleted, nil
+	}
+
+	length, err := a.client.XLen(ctx, topic).Result()
+	if err != nil {
+		return deleted, err
+	}
+
+	keep, err := a.retainLen(ctx, topic, length, policy)
+	if err != nil || length <= keep {
+		return deleted, err
+	}
+
+	if safeId != "" {
+		// the entries trimmed by length must all be older than the safe id, otherwise trim up to it
+		before, err := a.client.XRangeN(ctx, topic, "-", "("+safeId, length-keep).Result()
+		if err != nil {
+			return deleted, err
+		}
+
+		if int64(len(before)) < length-keep {
+			n, err := a.trimMinId(ctx, topic, safeId, policy.Exact)
+			return deleted + n, err
+		}
+	}
+
+	var n int64
+	if policy.Exact {
+		n, err = a.client.XTrimMaxLen(ctx, topic, keep).Result()
+	} else {
+		n, err = a.client.XTrimMaxLenApprox(ctx, topic, keep, 0).Result()
+	}
+
+	return deleted + n, err
+}
+
+// retainLen returns the entries kept by the length and the memory limits of policy
+func (a *Admin) retainLen(ctx context.Context, topic string, length int64, policy RetentionPolicy) (int64, error) {
+	keep := length
+	if policy.MaxLen > 0 && policy.MaxLen < keep {
+		keep = policy.MaxLen
+	}
+
+	if policy.MaxMemory <= 0 || length == 0 {
+		return keep, nil
+	}
+
+	usage, err := a.client.MemoryUsage(ctx, topic).Result()
+	if err == redis.Nil {
+		return keep, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	// entries are assumed to be about the same size
+	if usage > policy.MaxMemory {
+		if n := length * policy.MaxMemory / usage; n < keep {
+			keep = n
+		}
+	}
+
+	return keep, nil
+}
+
+// safeId returns the oldest entry still needed by a group of topic, its oldest pending entry or its last delivered one.
+// It is empty when topic has no group.
+func (a *Admin) safeId(ctx context.Context, topic string) (string, error) {
+	groups, err := xInfoGroups(ctx, a.client, topic)
+	if err != nil {
+		return "", err
+	}
+
+	safeId := ""
+	for _, g := range groups {
+		id := g.LastDeliveredId
+
+		if g.Pending > 0 {
+			p, err := a.client.XPending(ctx, topic, g.Name).Result()
+			if err != nil && err != redis.Nil {
+				return "", err
+			}
+
+			if p != nil && p.Count > 0 && compareStreamId(p.Lower, id) < 0 {
+				id = p.Lower
+			}
+		}
+
+		if safeId == "" || compareStreamId(id, safeId) < 0 {
+			safeId = id
+		}
+	}
+
+	return safeId, nil
+}
+
+func (a *Admin) trimMinId(ctx context.Context, topic, minId string, exact bool) (int64, error) {
+	if exact {
+		return a.client.XTrimMinID(ctx, topic, minId).Result()
+	}
+
+	return a.client.XTrimMinIDApprox(ctx, topic, minId, 0).Result()
+}
+
+// Retention trims topics by their policy every interval.
+// The processes sharing the key of the retention elect the one running it.
+type Retention struct {
+	rsq.ILogger
+
+	admin    *Admin
+	interval time.Duration
+	election *redisop.Election
+	policies map[string]RetentionPolicy
+	registry *Registry
+	mutex    sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+func NewRetention(key string, interval time.Duration, cl redis.UniversalClient, l rsq.ILogger) *Retention {
+	return &Retention{
+		ILogger: l,
+
+		admin:    NewAdmin(cl, l),
+		interval: interval,
+		election: redisop.NewElection(cl, l, key, 3*interval),
+		policies: make(map[string]RetentionPolicy),
+	}
+}
+
+// SetPolicy sets the policy of topic, it is applied from the next run
+func (r *Retention) SetPolicy(topic string, policy RetentionPolicy) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.policies[topic] = policy
+}
+
+// SetRegistry applies the retention of the topics registered in reg too, a policy set by SetPolicy overrides it
+func (r *Retention) SetRegistry(reg *Registry) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.registry = reg
+}
+
+func (r *Retention) RemovePolicy(topic string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.policies, topic)
+}
+
+func (r *Retention) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan bool)
+
+	go r.election.Run(ctx)
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if r.election.Leader() {
+					r.run(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// Stop waits for the running trims, the leadership is given up in the background
+func (r *Retention) Stop() {
+	if r.cancel == nil {
+		return
+	}
+
+	r.cancel()
+	<-r.done
+	r.cancel = nil
+}
+
+// run trims all the topics once
+func (r *Retention) run(ctx context.Context) {
+	r.mutex.Lock()
+	reg := r.registry
+	policies := make(map[string]RetentionPolicy, len(r.policies))
+	for k, v := range r.policies {
+		policies[k] = v
+	}
+	r.mutex.Unlock()
+
+	if reg != nil {
+		configs, err := reg.Topics(ctx)
+		if err != nil {
+			r.Errorf("retention read registry failed: %s", err)
+		}
+
+		for _, cfg := range configs {
+			if _, ok := policies[cfg.Name]; !ok && cfg.Retention != (RetentionPolicy{}) {
+				policies[cfg.Name] = cfg.Retention
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(policies))
+	for topic := range policies {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		if ctx.Err() != nil {
+			return
+		}
+
+		n, err := r.admin.Retain(ctx, topic, policies[topic])
+		if err != nil {
+			if ctx.Err() == nil {
+				r.Errorf("retention failed topic: %s, err: %s", topic, err)
+			}
+			continue
+		}
+
+		if n > 0 {
+			r.Infof("retention trimmed %d entries of %s", n, topic)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+	"time"
+)
+
+func TestRetain(t *testing.T) {
+	topic := "rsq:retention_test"
+	c, l := test.Dependency()
+	ctx := context.Background()
+	a := NewAdmin(c, l)
+
+	c.Del(ctx, topic)
+	for i := 0; i < 10; i++ {
+		c.XAdd(ctx, &redis.XAddArgs{Stream: topic, Values: []string{"k", "v"}})
+	}
+
+	c.XGroupCreate(ctx, topic, "g", "0")
+	c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "g", Consumer: "c", Streams: []string{topic, ">"}, Count: 3})
+
+	//the pending entries of the group are kept
+	n, err := a.Retain(ctx, topic, RetentionPolicy{MaxLen: 2, Safe: true, Exact: true})
+	if err != nil || n != 0 {
+		t.Fatalf("expect nothing trimmed, got %d %v", n, err)
+	}
+
+	first := c.XRange(ctx, topic, "-", "+").Val()[0].ID
+	c.XAck(ctx, topic, "g", first)
+
+	n, err = a.Retain(ctx, topic, RetentionPolicy{MaxLen: 2, Safe: true, Exact: true})
+	if err != nil || n != 1 {
+		t.Fatalf("expect the acked entry trimmed, got %d %v", n, err)
+	}
+
+	n, err = a.Retain(ctx, topic, RetentionPolicy{MaxLen: 2, Exact: true})
+	if err != nil || n != 7 {
+		t.Fatalf("expect 7 entries trimmed, got %d %v", n, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	n, err = a.Retain(ctx, topic, RetentionPolicy{MaxAge: 5 * time.Millisecond, Exact: true})
+	if err != nil || n != 2 {
+		t.Fatalf("expect the old entries trimmed, got %d %v", n, err)
+	}
+
+	c.Del(ctx, topic)
+}
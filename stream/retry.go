@@ -0,0 +1,282 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/kerror"
+	"math/rand"
+	"time"
+)
+
+// retryLease is how long a redelivered message is hidden from the other consumers of the group,
+// it is redelivered again when its consumer dies before handling it
+const retryLease = time.Minute
+
+const retryPollCount = 100
+
+// RetryPolicy tells how a group retries the messages its handler fails.
+// A message is retried Immediate times in process, then redelivered Delayed times through the delay queue of the group
+// with an exponential backoff, then dead lettered. A handler error with the code of kerror.PermanentError dead letters it at once.
+// The delay queue is polled before each read, a redelivery may be late by the block of the reads.
+type RetryPolicy struct {
+	Immediate  int           `json:"immediate,omitempty"`
+	Delayed    int           `json:"delayed,omitempty"`
+	Backoff    time.Duration `json:"backoff,omitempty"`    //delay of the first redelivery, doubled for each next one, 1s by default
+	MaxBackoff time.Duration `json:"maxBackoff,omitempty"` //0 for no cap
+	Jitter     float64       `json:"jitter,omitempty"`     //fraction of the delay randomly added or removed, in [0, 1]
+}
+
+func (p *RetryPolicy) enabled() bool {
+	return p.Immediate > 0 || p.Delayed > 0
+}
+
+// delay returns the delay of the nth redelivery
+func (p *RetryPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = time.Second
+	}
+
+	for i := 1; i < n && i < 32 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// permanent tells whether a handler error must not be retried
+func permanent(err error) bool {
+	var ke *kerror.KError
+	return errors.As(err, &ke) && ke.Code() == kerror.PermanentError.Code()
+}
+
+// retryRecord is a message waiting in the delay queue of a group
+type retryRecord struct {
+	Id      string            `json:"id"`
+	TagId   string            `json:"tag"`
+	Data    []byte            `json:"data"`
+	Header  map[string]string `json:"header,omitempty"`
+	EntryId string            `json:"entry"`
+	Attempt int               `json:"attempt"` //calls of the handler
+	Retries int               `json:"retries"` //redeliveries through the queue
+	Due     int64             `json:"due"`     //ms, keeps the members unique
+
+	member string //raw member in the zset
+}
+
+func (r *retryRecord) message(topic string) *rsq.Message {
+	return &rsq.Message{
+		Id:            r.Id,
+		TagId:         r.TagId,
+		Data:          r.Data,
+		Header:        r.Header,
+		Topic:         topic,
+		EntryId:       r.EntryId,
+		DeliveryCount: int64(r.Retries) + 1,
+		Attempt:       r.Attempt + 1,
+	}
+}
+
+// claimRetryScript returns the due members and hides them for the lease
+const claimRetryScript = `
+	local members = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+	for _, m in ipairs(members) do
+		redis.call("ZADD", KEYS[1], ARGV[2], m)
+	end
+	return members
+`
+
+var claimRetry = redis.NewScript(claimRetryScript)
+
+// retry retries a message failed with err in process, it returns the error of the last attempt
+func (g *Group) retry(msg *rsq.Message, err error) error {
+	for i := 0; err != nil && !permanent(err) && i < g.opts.retry.Immediate; i++ {
+		msg.Attempt++
+		err = handleMessage(g.tracer, g.handler, msg, g.group, g, g.ILogger)
+	}
+
+	return err
+}
+
+// retryBatch retries the messages of a batch failed with errs in process, it returns the errors of the last attempts
+func (g *Group) retryBatch(msgs []*rsq.Message, errs []error) []error {
+	if errs == nil {
+		return nil
+	}
+
+	errs = fill(errs, len(msgs))
+
+	for i := 0; i < g.opts.retry.Immediate; i++ {
+		var failed []*rsq.Message
+		var index []int
+		for j, err := range errs {
+			if err != nil && !permanent(err) {
+				msgs[j].Attempt++
+				failed = append(failed, msgs[j])
+				index = append(index, j)
+			}
+		}
+
+		if len(failed) == 0 {
+			break
+		}
+
+		retried := fill(handleBatch(g.tracer, g.batchHandler, failed, g.group, g, g.ILogger), len(failed))
+		for k, j := range index {
+			errs[j] = retried[k]
+		}
+	}
+
+	return errs
+}
+
+// fill returns an error per message, messages without result failed
+func fill(errs []error, n int) []error {
+	if errs == nil {
+		return make([]error, n)
+	}
+
+	filled := make([]error, n)
+	for i := range filled {
+		if i < len(errs) {
+			filled[i] = errs[i]
+		} else {
+			filled[i] = errMissingResult
+		}
+	}
+
+	return filled
+}
+
+// retryLater puts a message failed with err in the delay queue of the group, or dead letters it when it has no retry left.
+// retries is the redeliveries of the message so far, member its raw record when it was redelivered.
+// It returns an error when the message is neither queued nor dead lettered.
+func (g *Group) retryLater(ctx context.Context, msg *rsq.Message, err error, retries int, member string) error {
+	key := streamRetryKey(g.topic, g.group)
+
+	if permanent(err) || retries >= g.opts.retry.Delayed {
+		reason := fmt.Sprintf("failed %d attempts: %s", msg.Attempt, err)
+		if permanent(err) {
+			reason = err.Error()
+		}
+
+		if e := deadLetterMessage(ctx, g.client, msg, g.group, reason, g.opts.dlqMaxLen); e != nil {
+			return e
+		}
+
+		if member != "" {
+			return g.client.ZRem(ctx, key, member).Err()
+		}
+
+		return nil
+	}
+
+	due := time.Now().Add(g.opts.retry.delay(retries + 1)).UnixMilli()
+	b, e := json.Marshal(&retryRecord{
+		Id:      msg.Id,
+		TagId:   msg.TagId,
+		Data:    msg.Data,
+		Header:  msg.Header,
+		EntryId: msg.EntryId,
+		Attempt: msg.Attempt,
+		Retries: retries + 1,
+		Due:     due,
+	})
+	if e != nil {
+		return kerror.JsonError.Msg(e.Error())
+	}
+
+	_, e = g.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if member != "" {
+			pipe.ZRem(ctx, key, member)
+		}
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(due), Member: b})
+		return nil
+	})
+
+	return e
+}
+
+// redeliver hands the due messages of the delay queue to the handler
+func (g *Group) redeliver(ctx context.Context) {
+	if !g.opts.retry.enabled() || (g.handler == nil && g.batchHandler == nil) {
+		return
+	}
+
+	key := streamRetryKey(g.topic, g.group)
+	now := time.Now()
+
+	members, err := claimRetry.Run(ctx, g.client, []string{key},
+		now.UnixMilli(), now.Add(retryLease).UnixMilli(), retryPollCount).StringSlice()
+	if err != nil && err != redis.Nil {
+		g.Errorf("MQGroup:redeliver: %s, topic: %s, group: %s", err, g.topic, g.group)
+		return
+	}
+
+	records := make([]*retryRecord, 0, len(members))
+	msgs := make([]*rsq.Message, 0, len(members))
+	for _, m := range members {
+		r := &retryRecord{member: m}
+		if e := json.Unmarshal([]byte(m), r); e != nil {
+			g.Errorf("MQGroup:redeliver invalid record %s: %s", m, e)
+			_ = g.client.ZRem(ctx, key, m).Err()
+			continue
+		}
+
+		records = append(records, r)
+		msgs = append(msgs, r.message(g.topic))
+	}
+
+	if len(msgs) == 0 {
+		return
+	}
+
+	var errs []error
+	if g.batchHandler != nil {
+		errs = fill(handleBatch(g.tracer, g.batchHandler, msgs, g.group, g, g.ILogger), len(msgs))
+	} else {
+		errs = make([]error, len(msgs))
+		for i, msg := range msgs {
+			g.opts.throttle(g.topic, g.tagId, g.ILogger)
+			errs[i] = handleMessage(g.tracer, g.handler, msg, g.group, g, g.ILogger)
+		}
+	}
+
+	for i, r := range records {
+		var e error
+		if errs[i] == nil {
+			e = g.client.ZRem(ctx, key, r.member).Err()
+		} else {
+			e = g.retryLater(ctx, msgs[i], errs[i], r.Retries, r.member)
+		}
+
+		//the message is redelivered again after the lease
+		if e != nil {
+			g.Errorf("MQGroup:redeliver: %s, topic: %s, group: %s, id: %s", e, g.topic, g.group, r.Id)
+		}
+	}
+}
+
+// deadLetterMessage adds a message alone to the dead letter queue of its topic, maxLen 0 doesn't trim the queue
+func deadLetterMessage(ctx context.Context, client redis.UniversalClient, msg *rsq.Message, group, reason string, maxLen int64) error {
+	values := map[string]interface{}{
+		dlqFieldOrigin: msg.EntryId,
+		dlqFieldGroup:  group,
+		dlqFieldReason: reason,
+	}
+	appendMsg(values, 0, &MsgNode{Id: msg.Id, TagId: msg.TagId, Data: msg.Data, Header: msg.Header})
+
+	return client.XAdd(ctx, &redis.XAddArgs{Stream: streamDLQKey(msg.Topic), MaxLen: maxLen, Approx: true, Values: values}).Err()
+}
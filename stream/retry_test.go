@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"github.com/wsk15046/rsq/kerror"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{Delayed: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if got := p.delay(i + 1); got != d {
+			t.Fatalf("delay %d: %s, expected %s", i+1, got, d)
+		}
+	}
+
+	if d := (&RetryPolicy{}).delay(1); d != time.Second {
+		t.Fatalf("default backoff %s", d)
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(2); d < time.Second || d > 3*time.Second {
+			t.Fatalf("delay with jitter %s", d)
+		}
+	}
+
+	if (&RetryPolicy{}).enabled() {
+		t.Fatal("empty policy enabled")
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if !permanent(kerror.PermanentError.Msg("invalid order")) {
+		t.Fatal("permanent error not detected")
+	}
+
+	if !permanent(fmt.Errorf("handle: %w", kerror.PermanentError.Msg("invalid order"))) {
+		t.Fatal("wrapped permanent error not detected")
+	}
+
+	if permanent(errors.New("timeout")) || permanent(kerror.SystemError) {
+		t.Fatal("transient error detected as permanent")
+	}
+}
+
+func TestFill(t *testing.T) {
+	errs := fill([]error{nil, errors.New("bad")}, 3)
+	if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != errMissingResult {
+		t.Fatalf("fill: %v", errs)
+	}
+
+	if errs = fill(nil, 2); len(errs) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("fill nil: %v", errs)
+	}
+}
@@ -2,12 +2,12 @@ package stream
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/go-redis/redis/v8"
 	"github.com/wsk15046/rsq"
 	"github.com/wsk15046/rsq/redisop"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +22,18 @@ type ConsumerReporter struct {
 	topic    string
 	tag      string
 	fullName string
+	interval time.Duration
+
+	// empty for broadcast consumers
+	group    string
+	consumer string
+
+	paused atomic.Bool //paused remotely
+	local  atomic.Bool //paused by the process
+	onSeek func(id string) error
+
+	stop     chan bool
+	stopOnce sync.Once
 }
 
 func NewConsumerReport(topic, tag, fullName string, cl redis.UniversalClient, l rsq.ILogger) *ConsumerReporter {
@@ -33,6 +45,8 @@ func NewConsumerReport(topic, tag, fullName string, cl redis.UniversalClient, l
 		topic:    topic,
 		tag:      tag,
 		fullName: fullName,
+		interval: reportInterval * time.Second,
+		stop:     make(chan bool),
 
 		ILogger: l,
 		client:  cl,
@@ -41,6 +55,15 @@ func NewConsumerReport(topic, tag, fullName string, cl redis.UniversalClient, l
 	return cr
 }
 
+// NewGroupReport reports the lag of the group and the pending entries of consumer
+func NewGroupReport(topic, group, consumer, fullName string, cl redis.UniversalClient, l rsq.ILogger) *ConsumerReporter {
+	cr := NewConsumerReport(topic, group, fullName, cl, l)
+	cr.group = group
+	cr.consumer = consumer
+
+	return cr
+}
+
 func (cr *ConsumerReporter) Update(lastRead string, incr int64) {
 	cr.mutex.Lock()
 	defer cr.mutex.Unlock()
@@ -56,36 +79,44 @@ func (cr *ConsumerReporter) StartReport() {
 
 	singleReport := func() {
 		ctx := context.Background()
-		info, e := cr.client.XInfoStream(ctx, cr.topic).Result()
+		info, e := xInfoStream(ctx, cr.client, cr.topic)
 		if e != nil {
 			cr.Errorf("MQConsumer:xread:GetStatistic %s", e)
 			return
 		}
 
-		cr.mutex.RLock()
-
-		latency := int64(-1)
-		entryStr := strings.Split(info.LastEntry.ID, "-")
-		readStr := strings.Split(cr.lastRead, "-")
-
-		entryId, e1 := strconv.ParseInt(entryStr[0], 10, 64)
-		readId, e2 := strconv.ParseInt(readStr[0], 10, 64)
-		if e1 == nil && e2 == nil {
-			latency = entryId - readId
+		cr.mutex.Lock()
+		// broadcast consumers start reading from the last entry
+		if cr.lastRead == "" && cr.group == "" {
+			cr.lastRead = info.LastEntryId
 		}
-
+		lastRead := cr.lastRead
 		curTotal := cr.total
+		cr.mutex.Unlock()
+
+		cr.syncControl()
 
 		mqcs := &ConsumerStat{
-			Qps:         (curTotal - preTotal) / reportInterval,
+			Qps:         (curTotal - preTotal) * int64(time.Second) / int64(cr.interval),
 			TagId:       cr.tag,
-			LastEntryId: info.LastEntry.ID,
-			LastReadId:  cr.lastRead,
-			Latency:     latency,
+			LastEntryId: info.LastEntryId,
+			LastReadId:  lastRead,
+			EntriesRead: -1,
+			Paused:      cr.Paused(),
 			UpdateTime:  time.Unix(time.Now().Unix(), 0),
 		}
 
-		cr.mutex.RUnlock()
+		delivered := lastRead
+		if cr.group != "" {
+			var ok bool
+			if delivered, ok = cr.groupLag(ctx, info, mqcs); !ok {
+				return
+			}
+		} else {
+			mqcs.Lag = entriesBehind(info, lastRead)
+		}
+
+		mqcs.Latency = latencyOf(info, delivered, mqcs.Lag+mqcs.Pending)
 
 		r := redisop.NewRedisHash[ConsumerStat](cr.client, cr.ILogger)
 		statKey := streamStatKey(cr.topic)
@@ -100,8 +131,130 @@ func (cr *ConsumerReporter) StartReport() {
 
 	go func() {
 		for {
-			time.Sleep(reportInterval * time.Second)
-			singleReport()
+			select {
+			case <-cr.stop:
+				return
+			case <-time.After(cr.interval):
+				singleReport()
+			}
 		}
 	}()
 }
+
+// StopReport stops the reports started by StartReport
+func (cr *ConsumerReporter) StopReport() {
+	cr.stopOnce.Do(func() {
+		close(cr.stop)
+	})
+}
+
+// Paused tells whether the consumer has been paused remotely or by the process
+func (cr *ConsumerReporter) Paused() bool {
+	return cr.paused.Load() || cr.local.Load()
+}
+
+// syncControl loads the state set remotely for the consumer, a seek is applied once
+func (cr *ConsumerReporter) syncControl() {
+	ctx := context.Background()
+	ctlKey := streamCtlKey(cr.topic)
+
+	raw, err := cr.client.HGet(ctx, ctlKey, cr.fullName).Result()
+	if err != nil && err != redis.Nil {
+		cr.Errorf("get consumer control failed %s, err:%s", ctlKey, err)
+		return
+	}
+
+	ctl := &ConsumerControl{}
+	if raw != "" {
+		if err = json.Unmarshal([]byte(raw), ctl); err != nil {
+			cr.Errorf("unmarshal consumer control failed %s, err:%s", ctlKey, err)
+			return
+		}
+	}
+
+	paused := ctl.Paused
+	if cr.paused.Swap(paused) != paused {
+		cr.Infof("consumer %s of %s paused: %v", cr.fullName, cr.topic, paused)
+	}
+
+	if ctl.Seek == "" || cr.onSeek == nil {
+		return
+	}
+
+	if e := cr.onSeek(ctl.Seek); e != nil {
+		cr.Errorf("consumer %s of %s seek to %s failed: %s", cr.fullName, cr.topic, ctl.Seek, e)
+	} else {
+		cr.Infof("consumer %s of %s seeked to %s", cr.fullName, cr.topic, ctl.Seek)
+	}
+
+	//the seek is cleared only if the control wasn't changed meanwhile, a new pause, resume or seek is kept
+	cleared := ""
+	if ctl.Paused {
+		ctl.Seek = ""
+		b, e := json.Marshal(ctl)
+		if e != nil {
+			cr.Errorf("marshal consumer control failed %s, err:%s", ctlKey, e)
+			return
+		}
+		cleared = string(b)
+	}
+
+	if err = clearSeek.Run(ctx, cr.client, []string{ctlKey}, cr.fullName, raw, cleared).Err(); err != nil && err != redis.Nil {
+		cr.Errorf("clear consumer seek failed %s, err:%s", ctlKey, err)
+	}
+}
+
+// groupLag fills the lag of the group and the pending count of the consumer,
+// it returns the last delivered id of the group.
+func (cr *ConsumerReporter) groupLag(ctx context.Context, info *StreamInfo, mqcs *ConsumerStat) (string, bool) {
+	groups, e := xInfoGroups(ctx, cr.client, cr.topic)
+	if e != nil {
+		cr.Errorf("MQGroup:xInfoGroups %s, topic: %s, group: %s", e, cr.topic, cr.group)
+		return "", false
+	}
+
+	var gi *GroupInfo
+	for _, v := range groups {
+		if v.Name == cr.group {
+			gi = v
+			break
+		}
+	}
+
+	if gi == nil {
+		cr.Errorf("MQGroup:group not found, topic: %s, group: %s", cr.topic, cr.group)
+		return "", false
+	}
+
+	mqcs.EntriesRead = gi.EntriesRead
+	if gi.Lag >= 0 {
+		mqcs.Lag = gi.Lag
+	} else {
+		// redis < 7 or the lag can't be computed after deletions
+		mqcs.Lag = entriesBehind(info, gi.LastDeliveredId)
+	}
+
+	pending, e := pendingByConsumer(ctx, cr.client, cr.topic, cr.group)
+	if e != nil {
+		cr.Errorf("MQGroup:xPending %s, topic: %s, group: %s", e, cr.topic, cr.group)
+		return "", false
+	}
+	mqcs.Pending = pending[cr.consumer]
+
+	return gi.LastDeliveredId, true
+}
+
+// latencyOf returns the ms between the last entry and the last delivered one
+func latencyOf(info *StreamInfo, delivered string, unprocessed int64) int64 {
+	if unprocessed <= 0 {
+		return 0
+	}
+
+	entryMs, _, e1 := parseStreamId(info.LastEntryId)
+	readMs, _, e2 := parseStreamId(delivered)
+	if e1 != nil || e2 != nil || entryMs < readMs {
+		return 0
+	}
+
+	return entryMs - readMs
+}
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	"context"
+	"github.com/wsk15046/rsq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/wsk15046/rsq/stream"
+
+const (
+	attrSystem        = attribute.Key("messaging.system")
+	attrTopic         = attribute.Key("messaging.destination.name")
+	attrMsgId         = attribute.Key("messaging.message.id")
+	attrTag           = attribute.Key("messaging.rsq.tag")
+	attrGroup         = attribute.Key("messaging.rsq.group")
+	attrEntryId       = attribute.Key("messaging.rsq.entry_id")
+	attrDeliveryCount = attribute.Key("messaging.rsq.delivery_count")
+)
+
+// message headers carry the trace context in W3C format
+var tracePropagator = propagation.TraceContext{}
+
+// startPublishSpan starts the producer span and injects its context into a copy of header
+func startPublishSpan(ctx context.Context, tracer trace.Tracer, topic, id string, header map[string]string) (context.Context, trace.Span, map[string]string) {
+	ctx, span := tracer.Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attrSystem.String("rsq"),
+			attrTopic.String(topic),
+			attrMsgId.String(id),
+		))
+
+	h := make(map[string]string, len(header)+1)
+	for k, v := range header {
+		h[k] = v
+	}
+	tracePropagator.Inject(ctx, propagation.MapCarrier(h))
+
+	return ctx, span, h
+}
+
+// handleMessage calls h in a child span of the context extracted from the message headers
+func handleMessage(tracer trace.Tracer, h rsq.MessageHandler, msg *rsq.Message, group string, c rsq.IMQConsumer, l rsq.ILogger) error {
+	ctx := context.Background()
+	if len(msg.Header) > 0 {
+		ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(msg.Header))
+	}
+
+	attrs := []attribute.KeyValue{
+		attrSystem.String("rsq"),
+		attrTopic.String(msg.Topic),
+		attrMsgId.String(msg.Id),
+		attrTag.String(msg.TagId),
+		attrEntryId.String(msg.EntryId),
+		attrDeliveryCount.Int64(msg.DeliveryCount),
+	}
+	if group != "" {
+		attrs = append(attrs, attrGroup.String(group))
+	}
+
+	ctx, span := tracer.Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	err := h(ctx, msg, c)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		l.Errorf("handle message failed, topic: %s, id: %s, entry: %s, attempt: %d, err: %s", msg.Topic, msg.Id, msg.EntryId, msg.Attempt, err)
+	}
+
+	return err
+}
+
+func wrapHandler(h rsq.ConsumerHandler) rsq.MessageHandler {
+	return func(ctx context.Context, msg *rsq.Message, c rsq.IMQConsumer) error {
+		h(msg.Id, msg.Data, c)
+		return nil
+	}
+}
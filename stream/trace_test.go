@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestTracePropagation(t *testing.T) {
+	_, l := test.Dependency()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := newOptions(WithTracerProvider(tp)).tracer()
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "edge")
+
+	_, span, header := startPublishSpan(ctx, tracer, "rsq:trace_test", "1", map[string]string{"k": "v"})
+	span.End()
+
+	if header["traceparent"] == "" || header["k"] != "v" {
+		t.Fatalf("trace context not injected %v", header)
+	}
+
+	m := make(map[string]interface{})
+	appendMsg(m, 0, &MsgNode{Id: "1", TagId: "c1", Data: []byte("data"), Header: header})
+
+	values := make(map[string]interface{})
+	for k, v := range m {
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		values[k] = v
+	}
+
+	nodes := preTreatMsgs(values, l)
+	if nodes[0] == nil || nodes[0].Header["traceparent"] != header["traceparent"] {
+		t.Fatalf("header not decoded %v", nodes)
+	}
+
+	msg := &rsq.Message{
+		Id:            nodes[0].Id,
+		TagId:         nodes[0].TagId,
+		Data:          nodes[0].Data,
+		Header:        nodes[0].Header,
+		Topic:         "rsq:trace_test",
+		EntryId:       "1-0",
+		DeliveryCount: 2,
+	}
+
+	handleMessage(tracer, func(ctx context.Context, msg *rsq.Message, h rsq.IMQConsumer) error {
+		return errors.New("failed")
+	}, msg, "mygroup", nil, l)
+
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expect 3 spans, got %d", len(spans))
+	}
+
+	publish, process := spans[0], spans[1]
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() {
+		t.Error("process span is not a child of publish span")
+	}
+	if process.SpanContext.TraceID() != root.SpanContext().TraceID() {
+		t.Error("trace id not propagated")
+	}
+	if process.Status.Code != codes.Error {
+		t.Error("handler error not recorded")
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range process.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs[attrGroup].AsString() != "mygroup" || attrs[attrTag].AsString() != "c1" ||
+		attrs[attrEntryId].AsString() != "1-0" || attrs[attrDeliveryCount].AsInt64() != 2 {
+		t.Errorf("unexpected attributes %v", process.Attributes)
+	}
+}
@@ -0,0 +1,286 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XINFO replies are parsed by hand, the go-redis v8 parsers reject the extra fields returned by redis 7.
+
+// StreamInfo is the reply of XINFO STREAM, fields introduced by redis 7 are left empty on older servers
+type StreamInfo struct {
+	Length          int64
+	Groups          int64
+	LastGeneratedId string
+	MaxDeletedId    string
+	EntriesAdded    int64 // -1 when unknown
+	FirstEntryId    string
+	LastEntryId     string
+}
+
+// GroupInfo is one element of the reply of XINFO GROUPS
+type GroupInfo struct {
+	Name            string
+	Consumers       int64
+	Pending         int64
+	LastDeliveredId string
+	EntriesRead     int64 // -1 when unknown
+	Lag             int64 // -1 when unknown
+}
+
+// ConsumerInfo is one element of the reply of XINFO CONSUMERS
+type ConsumerInfo struct {
+	Name     string
+	Pending  int64
+	Idle     time.Duration //since the last attempted interaction
+	Inactive time.Duration //since the last successful interaction, -1 when unknown
+}
+
+func xInfoStream(ctx context.Context, client redis.UniversalClient, topic string) (*StreamInfo, error) {
+	reply, err := client.Do(ctx, "XINFO", "STREAM", topic).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &StreamInfo{EntriesAdded: -1}
+
+	for i := 0; i+1 < len(reply); i += 2 {
+		key, _ := reply[i].(string)
+		val := reply[i+1]
+
+		switch key {
+		case "length":
+			info.Length = replyInt(val)
+		case "groups":
+			info.Groups = replyInt(val)
+		case "last-generated-id":
+			info.LastGeneratedId = replyString(val)
+		case "max-deleted-entry-id":
+			info.MaxDeletedId = replyString(val)
+		case "entries-added":
+			info.EntriesAdded = replyInt(val)
+		case "first-entry":
+			info.FirstEntryId = replyEntryId(val)
+		case "last-entry":
+			info.LastEntryId = replyEntryId(val)
+		}
+	}
+
+	return info, nil
+}
+
+func xInfoGroups(ctx context.Context, client redis.UniversalClient, topic string) ([]*GroupInfo, error) {
+	reply, err := client.Do(ctx, "XINFO", "GROUPS", topic).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*GroupInfo, 0, len(reply))
+
+	for _, r := range reply {
+		fields, ok := r.([]interface{})
+		if !ok {
+			continue
+		}
+
+		info := &GroupInfo{EntriesRead: -1, Lag: -1}
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			val := fields[i+1]
+
+			switch key {
+			case "name":
+				info.Name = replyString(val)
+			case "consumers":
+				info.Consumers = replyInt(val)
+			case "pending":
+				info.Pending = replyInt(val)
+			case "last-delivered-id":
+				info.LastDeliveredId = replyString(val)
+			case "entries-read":
+				if val != nil {
+					info.EntriesRead = replyInt(val)
+				}
+			case "lag":
+				if val != nil {
+					info.Lag = replyInt(val)
+				}
+			}
+		}
+
+		groups = append(groups, info)
+	}
+
+	return groups, nil
+}
+
+func xInfoConsumers(ctx context.Context, client redis.UniversalClient, topic, group string) ([]*ConsumerInfo, error) {
+	reply, err := client.Do(ctx, "XINFO", "CONSUMERS", topic, group).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := make([]*ConsumerInfo, 0, len(reply))
+
+	for _, r := range reply {
+		fields, ok := r.([]interface{})
+		if !ok {
+			continue
+		}
+
+		info := &ConsumerInfo{Inactive: -1}
+
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			val := fields[i+1]
+
+			switch key {
+			case "name":
+				info.Name = replyString(val)
+			case "pending":
+				info.Pending = replyInt(val)
+			case "idle":
+				info.Idle = time.Duration(replyInt(val)) * time.Millisecond
+			case "inactive":
+				if n := replyInt(val); n >= 0 {
+					info.Inactive = time.Duration(n) * time.Millisecond
+				}
+			}
+		}
+
+		consumers = append(consumers, info)
+	}
+
+	return consumers, nil
+}
+
+// pendingByConsumer returns the pending entries count of each consumer of group
+func pendingByConsumer(ctx context.Context, client redis.UniversalClient, topic, group string) (map[string]int64, error) {
+	pending, err := client.XPending(ctx, topic, group).Result()
+
+	// go-redis v8 can't parse the reply of an empty pending list
+	if err == redis.Nil {
+		return map[string]int64{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pending.Consumers, nil
+}
+
+func replyInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+
+	return 0
+}
+
+func replyString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case int64:
+		return strconv.FormatInt(s, 10)
+	}
+
+	return ""
+}
+
+func replyEntryId(v interface{}) string {
+	entry, ok := v.([]interface{})
+	if !ok || len(entry) == 0 {
+		return ""
+	}
+
+	return replyString(entry[0])
+}
+
+// parseStreamId splits an entry id "<ms>-<seq>"
+func parseStreamId(id string) (ms, seq int64, err error) {
+	vals := strings.SplitN(id, "-", 2)
+
+	ms, err = strconv.ParseInt(vals[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream id [%s]", id)
+	}
+
+	if len(vals) == 2 {
+		seq, err = strconv.ParseInt(vals[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid stream id [%s]", id)
+		}
+	}
+
+	return ms, seq, nil
+}
+
+// compareStreamId returns -1, 0 or 1, invalid ids are the smallest
+func compareStreamId(a, b string) int {
+	ams, aseq, ea := parseStreamId(a)
+	bms, bseq, eb := parseStreamId(b)
+
+	switch {
+	case ea != nil && eb != nil:
+		return 0
+	case ea != nil:
+		return -1
+	case eb != nil:
+		return 1
+	case ams != bms:
+		if ams < bms {
+			return -1
+		}
+		return 1
+	case aseq != bseq:
+		if aseq < bseq {
+			return -1
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// entriesBehind estimates the number of entries after lastRead,
+// assuming entries are spread evenly over the time range of the stream.
+func entriesBehind(info *StreamInfo, lastRead string) int64 {
+	if info.Length == 0 || info.LastEntryId == "" || compareStreamId(lastRead, info.LastEntryId) >= 0 {
+		return 0
+	}
+
+	if compareStreamId(lastRead, info.FirstEntryId) < 0 {
+		return info.Length
+	}
+
+	firstMs, _, _ := parseStreamId(info.FirstEntryId)
+	lastMs, _, _ := parseStreamId(info.LastEntryId)
+	readMs, _, _ := parseStreamId(lastRead)
+
+	if lastMs <= firstMs {
+		return 1
+	}
+
+	behind := info.Length * (lastMs - readMs) / (lastMs - firstMs)
+	if behind < 1 {
+		behind = 1
+	}
+
+	return behind
+}
+
+// CompareEntryId compares two stream entry ids, it returns -1, 0 or 1
+func CompareEntryId(a, b string) int {
+	return compareStreamId(a, b)
+}
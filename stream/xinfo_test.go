@@ -0,0 +1,33 @@
+package stream
+
+import "testing"
+
+func TestEntriesBehind(t *testing.T) {
+	info := &StreamInfo{
+		Length:       1000,
+		FirstEntryId: "1000-0",
+		LastEntryId:  "2000-5",
+	}
+
+	cases := []struct {
+		lastRead string
+		behind   int64
+	}{
+		{"2000-5", 0},
+		{"3000-0", 0},
+		{"", 1000},
+		{"999-9", 1000},
+		{"1500-0", 500},
+		{"2000-4", 1},
+	}
+
+	for _, c := range cases {
+		if b := entriesBehind(info, c.lastRead); b != c.behind {
+			t.Errorf("lastRead %s, expect %d, got %d", c.lastRead, c.behind, b)
+		}
+	}
+
+	if compareStreamId("10-2", "9-3") != 1 || compareStreamId("10-2", "10-3") != -1 || compareStreamId("10", "10-0") != 0 {
+		t.Error("compareStreamId failed")
+	}
+}
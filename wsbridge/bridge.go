@@ -0,0 +1,252 @@
+// Package wsbridge fans out rsq topics to websocket clients such as browsers.
+//
+//	mux.Handle("/ws", wsbridge.New(client, logger, wsbridge.WithAuth(wsbridge.AllowTopics("orders.*"))))
+//
+// A connection subscribes to a topic and a tag by sending
+//
+//	{"type": "subscribe", "topic": "t", "tag": "a", "from": "1700000000000-0"}
+//
+// or with the query of the url: /ws?topic=t&tag=a&from=1700000000000-0.
+// The subscription receives the messages of its tag and the broadcast ones, all of them without tag.
+// The messages written after from are sent first, a client reconnecting with the entry id
+// of the last message it got misses nothing still in the stream.
+//
+// The bridge reads each topic and tag once with a broadcast consumer shared by the connections,
+// the consumer is stopped when the last of them leaves. Subscriptions are refused unless an Authorizer allows them.
+package wsbridge
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/internal/edge"
+	"github.com/wsk15046/rsq/stream"
+	"github.com/wsk15046/rsq/util"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Policy tells what to do with a message when the buffer of a slow connection is full
+type Policy int
+
+const (
+	// DropOldest drops the oldest buffered message
+	DropOldest Policy = iota
+	// DropNewest drops the message
+	DropNewest
+	// Disconnect closes the connection, the client resumes from the last message it got
+	Disconnect
+)
+
+// Authorizer allows a connection to subscribe to a topic, subscriptions are refused when no Authorizer is set
+type Authorizer func(r *http.Request, topic, tag string) error
+
+// AllowTopics allows the subscriptions to the topics matching one of patterns, see path.Match
+func AllowTopics(patterns ...string) Authorizer {
+	return func(r *http.Request, topic, tag string) error {
+		return edge.MatchTopic(patterns, topic)
+	}
+}
+
+type Option func(b *Bridge)
+
+// WithAuth gates the subscriptions behind auth
+func WithAuth(auth Authorizer) Option {
+	return func(b *Bridge) {
+		b.auth = auth
+	}
+}
+
+// WithCheckOrigin sets the check of the origin of the upgrade requests, same origin only by default
+func WithCheckOrigin(check func(r *http.Request) bool) Option {
+	return func(b *Bridge) {
+		b.upgrader.CheckOrigin = check
+	}
+}
+
+// WithBuffer sets the messages buffered by a connection and what to do when it is full, size must be above 0
+func WithBuffer(size int, policy Policy) Option {
+	return func(b *Bridge) {
+		if size > 0 {
+			b.buffer = size
+		}
+		b.policy = policy
+	}
+}
+
+// WithMaxResume sets the max entries sent to resume a subscription
+func WithMaxResume(n int64) Option {
+	return func(b *Bridge) {
+		b.maxResume = n
+	}
+}
+
+// WithStreamOptions sets the options of the consumers created by the bridge
+func WithStreamOptions(opts ...stream.Option) Option {
+	return func(b *Bridge) {
+		b.streamOpts = opts
+	}
+}
+
+type Bridge struct {
+	rsq.ILogger
+
+	id           string //distinguishes the consumers of the bridge from those of the other bridges and applications
+	client       redis.UniversalClient
+	admin        *stream.Admin
+	upgrader     websocket.Upgrader
+	auth         Authorizer
+	buffer       int
+	policy       Policy
+	maxResume    int64
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	streamOpts   []stream.Option
+
+	hubs  map[hubKey]*hub
+	mutex sync.Mutex
+}
+
+func New(cl redis.UniversalClient, l rsq.ILogger, opts ...Option) *Bridge {
+	b := &Bridge{
+		ILogger: l,
+
+		id:           util.RandString(8),
+		client:       cl,
+		admin:        stream.NewAdmin(cl, l),
+		buffer:       256,
+		policy:       DropOldest,
+		maxResume:    1000,
+		writeTimeout: 10 * time.Second,
+		pingInterval: 30 * time.Second,
+		hubs:         make(map[hubKey]*hub),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+type hubKey struct {
+	topic string
+	tag   string
+}
+
+// hub shares the messages read by a consumer between the subscriptions of its topic and tag
+type hub struct {
+	consumer rsq.IMQConsumer
+	subs     map[*subscription]struct{}
+	stopped  bool
+	started  chan struct{} //closed once the consumer reads the topic
+	mutex    sync.RWMutex
+}
+
+// join adds sub to the hub of its topic and tag, the consumer of the hub is started on the first join.
+// It returns once the consumer reads the topic so that a resume misses nothing.
+func (b *Bridge) join(sub *subscription) {
+	key := hubKey{topic: sub.topic, tag: sub.tag}
+
+	b.mutex.Lock()
+	h, ok := b.hubs[key]
+	if !ok {
+		h = &hub{subs: make(map[*subscription]struct{}), started: make(chan struct{})}
+		b.hubs[key] = h
+	}
+
+	h.mutex.Lock()
+	h.subs[sub] = struct{}{}
+	h.mutex.Unlock()
+	b.mutex.Unlock()
+
+	if ok {
+		<-h.started
+		return
+	}
+
+	// the consumer calls redis, the other topics are not held up meanwhile
+	name := fmt.Sprintf("wsbridge.%s.%s", b.id, sub.tag)
+	opts := append([]stream.Option{stream.WithTag(sub.tag)}, b.streamOpts...)
+
+	c := stream.NewConsumer(sub.topic, name, b.client, b.ILogger, opts...)
+	c.SetMessageHandler(h.dispatch)
+	c.Subscribe()
+
+	h.mutex.Lock()
+	h.consumer = c
+	stopped := h.stopped
+	h.mutex.Unlock()
+	close(h.started)
+
+	// the hub was left while the consumer started
+	if stopped {
+		c.Stop()
+	}
+}
+
+// stop stops the consumer of the hub, or marks the hub so that the consumer is stopped once started
+func (h *hub) stop() {
+	h.mutex.Lock()
+	c := h.consumer
+	h.stopped = true
+	h.mutex.Unlock()
+
+	if c != nil {
+		c.Stop()
+	}
+}
+
+// leave removes sub from its hub, the consumer of the hub is stopped on the last leave
+func (b *Bridge) leave(sub *subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	key := hubKey{topic: sub.topic, tag: sub.tag}
+
+	h, ok := b.hubs[key]
+	if !ok {
+		return
+	}
+
+	h.mutex.Lock()
+	delete(h.subs, sub)
+	empty := len(h.subs) == 0
+	h.mutex.Unlock()
+
+	if empty {
+		h.stop()
+		delete(b.hubs, key)
+	}
+}
+
+// Close stops the consumers of the bridge
+func (b *Bridge) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for key, h := range b.hubs {
+		h.stop()
+		delete(b.hubs, key)
+	}
+}
+
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, e := b.upgrader.Upgrade(w, r, nil)
+	if e != nil {
+		// the upgrader has answered the request
+		b.Debugf("wsbridge upgrade failed: %s", e)
+		return
+	}
+
+	c := newConn(b, ws, r)
+
+	if topic := r.URL.Query().Get("topic"); topic != "" {
+		q := r.URL.Query()
+		c.subscribe(&frame{Type: frameSubscribe, Topic: topic, Tag: q.Get("tag"), From: q.Get("from")})
+	}
+
+	c.serve()
+}
@@ -0,0 +1,118 @@
+package wsbridge
+
+import (
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/test"
+	"testing"
+)
+
+func testConn(policy Policy, buffer int) *conn {
+	return &conn{
+		Bridge:  &Bridge{policy: policy, buffer: buffer},
+		out:     make(chan *frame, buffer),
+		closing: make(chan struct{}),
+	}
+}
+
+func TestSendPolicy(t *testing.T) {
+	cases := []struct {
+		policy Policy
+		expect []string
+	}{
+		{DropOldest, []string{"2", "3"}},
+		{DropNewest, []string{"1", "2"}},
+	}
+
+	for _, tc := range cases {
+		c := testConn(tc.policy, 2)
+		for _, id := range []string{"1", "2", "3"} {
+			c.send(&frame{Type: frameMessage, From: id})
+		}
+
+		close(c.out)
+		var got []string
+		for f := range c.out {
+			got = append(got, f.From)
+		}
+
+		if len(got) != len(tc.expect) || got[0] != tc.expect[0] || got[1] != tc.expect[1] {
+			t.Errorf("policy %d expect %v, got %v", tc.policy, tc.expect, got)
+		}
+	}
+}
+
+func TestSubscriptionDedupe(t *testing.T) {
+	c := testConn(DropNewest, 10)
+	sub := &subscription{conn: c, topic: "t", tag: "a", last: "5-0"}
+
+	for _, m := range []*rsq.Message{
+		{Id: "old", EntryId: "4-0"},
+		{Id: "x", EntryId: "5-0"},
+		{Id: "y", EntryId: "6-0"},
+		{Id: "z", EntryId: "6-0"},
+	} {
+		sub.deliver(m)
+	}
+
+	if n := len(c.out); n != 3 {
+		t.Errorf("expect 3 messages, got %d", n)
+	}
+
+	if !sub.match("$") || !sub.match("a") || sub.match("b") {
+		t.Errorf("unexpected tag match of %s", sub.tag)
+	}
+}
+
+func TestHubTeardown(t *testing.T) {
+	c, l := test.Dependency()
+	b := New(c, l)
+	defer b.Close()
+
+	subs := []*subscription{{topic: "rsq:ws_test", tag: "a"}, {topic: "rsq:ws_test", tag: "a"}}
+	for _, sub := range subs {
+		b.join(sub)
+	}
+
+	if n := len(b.hubs); n != 1 {
+		t.Fatalf("expect a hub shared by the subscriptions, got %d", n)
+	}
+
+	//the consumer of the hub reads the tag under a name of the bridge
+	h := b.hubs[hubKey{topic: "rsq:ws_test", tag: "a"}]
+	if h.consumer.TagId() != "a" || h.consumer.FullName() == "consumer@a" {
+		t.Errorf("unexpected consumer %s of the tag %s", h.consumer.FullName(), h.consumer.TagId())
+	}
+
+	b.leave(subs[0])
+	if n := len(b.hubs); n != 1 {
+		t.Errorf("expect the hub kept for the other subscription, got %d", n)
+	}
+
+	b.leave(subs[1])
+	if n := len(b.hubs); n != 0 {
+		t.Errorf("expect the hub stopped after the last leave, got %d", n)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	c, l := test.Dependency()
+
+	if b := New(c, l, WithBuffer(0, DropOldest)); b.buffer != 256 {
+		t.Errorf("expect the default buffer, got %d", b.buffer)
+	}
+
+	if b := New(c, l, WithBuffer(8, Disconnect)); b.buffer != 8 || b.policy != Disconnect {
+		t.Errorf("unexpected buffer %d %d", b.buffer, b.policy)
+	}
+}
+
+func TestSubscribeWithoutAuth(t *testing.T) {
+	c := testConn(DropNewest, 10)
+	c.subs = make(map[hubKey]*subscription)
+
+	c.subscribe(&frame{Type: frameSubscribe, Topic: "t"})
+
+	if f := <-c.out; f.Type != frameError || len(c.subs) != 0 {
+		t.Errorf("expect the subscription refused, got %+v", f)
+	}
+}
@@ -0,0 +1,363 @@
+package wsbridge
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/wsk15046/rsq"
+	"github.com/wsk15046/rsq/stream"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	frameSubscribe   = "subscribe"
+	frameUnsubscribe = "unsubscribe"
+	frameSubscribed  = "subscribed"
+	frameMessage     = "message"
+	frameGap         = "gap" //the resume stopped at the max resume, from is the last entry sent
+	frameError       = "error"
+
+	tagAll         = "$"
+	encodingBase64 = "base64"
+	resumePage     = 100
+)
+
+type frame struct {
+	Type    string   `json:"type"`
+	Topic   string   `json:"topic,omitempty"`
+	Tag     string   `json:"tag,omitempty"`
+	From    string   `json:"from,omitempty"`
+	Message *message `json:"message,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type message struct {
+	Id       string            `json:"id"`
+	TagId    string            `json:"tagId"`
+	EntryId  string            `json:"entryId"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Data     string            `json:"data"`
+	Encoding string            `json:"encoding,omitempty"`
+}
+
+// newMessage encodes data in base64 when it isn't valid utf8
+func newMessage(m *rsq.Message) *message {
+	out := &message{
+		Id:      m.Id,
+		TagId:   m.TagId,
+		EntryId: m.EntryId,
+		Headers: m.Header,
+	}
+
+	if utf8.Valid(m.Data) {
+		out.Data = string(m.Data)
+	} else {
+		out.Data = base64.StdEncoding.EncodeToString(m.Data)
+		out.Encoding = encodingBase64
+	}
+
+	return out
+}
+
+type conn struct {
+	*Bridge
+
+	ws      *websocket.Conn
+	r       *http.Request
+	out     chan *frame
+	closing chan struct{}
+	once    sync.Once
+
+	subs  map[hubKey]*subscription
+	mutex sync.Mutex
+}
+
+func newConn(b *Bridge, ws *websocket.Conn, r *http.Request) *conn {
+	return &conn{
+		Bridge:  b,
+		ws:      ws,
+		r:       r,
+		out:     make(chan *frame, b.buffer),
+		closing: make(chan struct{}),
+		subs:    make(map[hubKey]*subscription),
+	}
+}
+
+// serve reads the frames of the client until the connection is closed
+func (c *conn) serve() {
+	go c.write()
+
+	defer c.close()
+
+	for {
+		var f frame
+		if e := c.ws.ReadJSON(&f); e != nil {
+			if !websocket.IsCloseError(e, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.Debugf("wsbridge read failed: %s", e)
+			}
+			return
+		}
+
+		switch f.Type {
+		case frameSubscribe:
+			c.subscribe(&f)
+		case frameUnsubscribe:
+			c.unsubscribe(&f)
+		default:
+			c.send(&frame{Type: frameError, Error: fmt.Sprintf("unknown frame type %s", f.Type)})
+		}
+	}
+}
+
+func (c *conn) write() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closing:
+			return
+		case f := <-c.out:
+			_ = c.ws.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			if e := c.ws.WriteJSON(f); e != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			if e := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeTimeout)); e != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.closing)
+
+		c.mutex.Lock()
+		for key, sub := range c.subs {
+			c.leave(sub)
+			delete(c.subs, key)
+		}
+		c.mutex.Unlock()
+
+		_ = c.ws.Close()
+	})
+}
+
+// send buffers f for the client, it applies the policy of the bridge when the buffer is full
+func (c *conn) send(f *frame) {
+	select {
+	case <-c.closing:
+		return
+	case c.out <- f:
+		return
+	default:
+	}
+
+	switch c.policy {
+	case DropOldest:
+		for {
+			select {
+			case <-c.out:
+			default:
+			}
+
+			select {
+			case c.out <- f:
+				return
+			default:
+			}
+		}
+	case Disconnect:
+		c.Warnf("wsbridge disconnects slow client %s", c.r.RemoteAddr)
+		_ = c.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow client"), time.Now().Add(c.writeTimeout))
+		// the hub calling send can't wait for the subscriptions to leave it
+		go c.close()
+	}
+}
+
+func (c *conn) subscribe(f *frame) {
+	if f.Topic == "" {
+		c.send(&frame{Type: frameError, Error: "topic is required"})
+		return
+	}
+
+	tag := f.Tag
+	if tag == "" {
+		tag = tagAll
+	}
+
+	if c.auth == nil {
+		c.send(&frame{Type: frameError, Topic: f.Topic, Tag: tag, Error: "no authorizer"})
+		return
+	}
+
+	if e := c.auth(c.r, f.Topic, tag); e != nil {
+		c.send(&frame{Type: frameError, Topic: f.Topic, Tag: tag, Error: e.Error()})
+		return
+	}
+
+	key := hubKey{topic: f.Topic, tag: tag}
+
+	c.mutex.Lock()
+	if _, ok := c.subs[key]; ok {
+		c.mutex.Unlock()
+		return
+	}
+
+	sub := &subscription{conn: c, topic: f.Topic, tag: tag, last: f.From, resuming: f.From != ""}
+	c.subs[key] = sub
+	c.mutex.Unlock()
+
+	// new messages are held by the subscription until the resume is done
+	c.join(sub)
+	c.send(&frame{Type: frameSubscribed, Topic: f.Topic, Tag: tag})
+
+	if sub.resuming {
+		go sub.resume()
+	}
+}
+
+func (c *conn) unsubscribe(f *frame) {
+	tag := f.Tag
+	if tag == "" {
+		tag = tagAll
+	}
+
+	key := hubKey{topic: f.Topic, tag: tag}
+
+	c.mutex.Lock()
+	sub, ok := c.subs[key]
+	delete(c.subs, key)
+	c.mutex.Unlock()
+
+	if ok {
+		c.leave(sub)
+	}
+}
+
+type subscription struct {
+	conn  *conn
+	topic string
+	tag   string
+
+	mutex    sync.Mutex
+	last     string //entry id of the last message sent
+	resuming bool
+	held     []*rsq.Message
+}
+
+// match tells whether the subscription gets a message of tagId
+func (s *subscription) match(tagId string) bool {
+	return s.tag == tagAll || tagId == tagAll || tagId == s.tag
+}
+
+// deliver sends m unless it has been sent by the resume
+func (s *subscription) deliver(m *rsq.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.resuming {
+		s.held = append(s.held, m)
+		return
+	}
+
+	s.sendLocked(m)
+}
+
+func (s *subscription) sendLocked(m *rsq.Message) {
+	// the messages of an entry share its id, they come one after the other
+	if s.last != "" && stream.CompareEntryId(m.EntryId, s.last) < 0 {
+		return
+	}
+
+	s.last = m.EntryId
+	s.conn.send(&frame{Type: frameMessage, Topic: s.topic, Tag: s.tag, Message: newMessage(m)})
+}
+
+// resume sends the messages written after the entry id given by the client, then the held ones
+func (s *subscription) resume() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	s.mutex.Lock()
+	from := s.last
+	s.mutex.Unlock()
+
+	sent := int64(0)
+	complete := false
+
+	for sent < s.conn.maxResume {
+		count := s.conn.maxResume - sent
+		if count > resumePage {
+			count = resumePage
+		}
+
+		entries, e := s.conn.admin.Peek(ctx, s.topic, "("+from, count)
+		if e != nil {
+			s.conn.Errorf("wsbridge resume failed topic: %s, from: %s, err: %s", s.topic, from, e)
+			s.conn.send(&frame{Type: frameError, Topic: s.topic, Tag: s.tag, Error: e.Error()})
+			break
+		}
+
+		s.mutex.Lock()
+		for _, entry := range entries {
+			for _, m := range entry.Messages {
+				if s.match(m.TagId) {
+					s.sendLocked(m)
+				}
+			}
+			from = entry.Id
+		}
+		s.mutex.Unlock()
+
+		sent += int64(len(entries))
+
+		if int64(len(entries)) < count {
+			complete = true
+			break
+		}
+	}
+
+	if !complete && sent >= s.conn.maxResume {
+		more, e := s.conn.admin.Peek(ctx, s.topic, "("+from, 1)
+		complete = e == nil && len(more) == 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !complete {
+		s.conn.send(&frame{Type: frameGap, Topic: s.topic, Tag: s.tag, From: from})
+	}
+
+	// the held messages may have been sent by the resume
+	for _, m := range s.held {
+		if stream.CompareEntryId(m.EntryId, from) > 0 {
+			s.sendLocked(m)
+		}
+	}
+
+	s.held = nil
+	s.resuming = false
+}
+
+// dispatch is the handler of the consumer of the hub
+func (h *hub) dispatch(ctx context.Context, m *rsq.Message, c rsq.IMQConsumer) error {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for sub := range h.subs {
+		sub.deliver(m)
+	}
+
+	return nil
+}